@@ -0,0 +1,270 @@
+package sisyphus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// backupPrefix is the file name prefix of all backups in a Maildir. The
+// backup time is appended, so backups sort by name from oldest to newest.
+const backupPrefix = "sisyphus.db.backup"
+
+// backupTimeFormat is the time format appended to backup file names
+const backupTimeFormat = "20060102T150405Z"
+
+// Backups returns the paths of all backups of a Maildir's database, newest
+// first.
+func (d Maildir) Backups() (backups []string, err error) {
+	backups, err = filepath.Glob(filepath.Join(string(d), backupPrefix+"*"))
+	if err != nil {
+		return backups, err
+	}
+
+	// skip unfinished backups
+	var complete []string
+	for _, b := range backups {
+		if filepath.Ext(b) != ".tmp" {
+			complete = append(complete, b)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(complete)))
+
+	return complete, nil
+}
+
+// decryptedSuffix is appended to the path of an encrypted backup to name its
+// decrypted copy. It ends in .tmp, so the copy is not taken for a backup.
+const decryptedSuffix = ".decrypted.tmp"
+
+// openBackup opens the backup at path read-only. Encrypted backups are
+// decrypted into a temporary copy, which is removed right after being
+// opened; the database remains readable until it is closed.
+func openBackup(path string) (db *bolt.DB, err error) {
+	enc, err := encryptedFile(path)
+	if err != nil {
+		return db, err
+	}
+	if !enc {
+		return OpenDatabaseReadOnly(path, time.Second)
+	}
+
+	plain := path + decryptedSuffix
+	err = decryptFile(path, plain)
+	if err != nil {
+		return db, err
+	}
+	defer os.Remove(plain)
+
+	return OpenDatabaseReadOnly(plain, time.Second)
+}
+
+// VerifyBackup checks that the database at path is consistent and holds all
+// buckets sisyphus requires. Encrypted backups are decrypted first.
+func VerifyBackup(path string) error {
+
+	db, err := openBackup(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		for _, path := range modelBuckets {
+			if bucketPath(tx, path) == nil {
+				return fmt.Errorf("bucket %s missing", path)
+			}
+		}
+
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return errs[0]
+		}
+
+		return nil
+	})
+}
+
+// Backup writes a timestamped copy of the database into the Maildir,
+// verifies it, and removes the oldest backups so that at most keep backups
+// remain. The copy is encrypted if encryption keys are set. It returns the
+// path of the new backup.
+func (d Maildir) Backup(db *bolt.DB, keep int) (path string, err error) {
+
+	path = filepath.Join(string(d), backupPrefix+"."+time.Now().UTC().Format(backupTimeFormat))
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return path, err
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return path, err
+	}
+
+	err = VerifyBackup(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return path, err
+	}
+
+	if len(keyring) > 0 {
+		plain := tmp
+		tmp = path + ".enc.tmp"
+		err = encryptFile(plain, tmp)
+		os.Remove(plain)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return path, err
+	}
+
+	log.WithFields(log.Fields{
+		"backup": path,
+	}).Info("Database backed up")
+
+	return path, d.pruneBackups(keep)
+}
+
+// pruneBackups removes all but the newest keep backups. A keep of zero or
+// less retains all backups.
+func (d Maildir) pruneBackups(keep int) error {
+	if keep < 1 {
+		return nil
+	}
+
+	backups, err := d.Backups()
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(backups); i++ {
+		err = os.Remove(backups[i])
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"backup": backups[i],
+		}).Info("Old backup removed")
+	}
+
+	return nil
+}
+
+// Restore replaces the Maildir's database by the given backup, or by the
+// newest backup if path is empty. The database must not be in use, so the
+// daemon has to be stopped first. The replaced database is kept as
+// sisyphus.db.replaced.
+func (d Maildir) Restore(path string) (restored string, err error) {
+
+	if path == "" {
+		backups, err := d.Backups()
+		if err != nil {
+			return path, err
+		}
+		if len(backups) == 0 {
+			return path, errors.New("no backup found")
+		}
+		path = backups[0]
+	}
+
+	err = VerifyBackup(path)
+	if err != nil {
+		return path, err
+	}
+
+	// Copy instead of rename, so the backup remains available. The copy is
+	// complete and on disk before the database is touched.
+	dbFile := filepath.Join(string(d), "sisyphus.db")
+	tmp := dbFile + ".tmp"
+	enc, err := encryptedFile(path)
+	if err == nil && enc {
+		err = decryptFile(path, tmp)
+	} else if err == nil {
+		err = copyFile(path, tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return path, err
+	}
+
+	replaced := false
+	if _, err = os.Stat(dbFile); err == nil {
+		// Make sure nobody is using the database while we swap it
+		db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			os.Remove(tmp)
+			return path, ErrDatabaseInUse
+		}
+		defer db.Close()
+
+		err = os.Rename(dbFile, dbFile+".replaced")
+		if err != nil {
+			os.Remove(tmp)
+			return path, err
+		}
+		replaced = true
+	}
+
+	err = os.Rename(tmp, dbFile)
+	if err != nil {
+		os.Remove(tmp)
+		if replaced {
+			os.Rename(dbFile+".replaced", dbFile)
+		}
+	}
+
+	return path, err
+}
+
+// copyFile copies the file at src to dst and syncs it to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
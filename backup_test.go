@@ -0,0 +1,97 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backup", func() {
+
+	Context("Backup and restore a database", func() {
+		BeforeEach(func() {
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			files, err := filepath.Glob("test/Maildir/sisyphus.db*")
+			Ω(err).ShouldNot(HaveOccurred())
+			for _, f := range files {
+				err = os.Remove(f)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+		})
+
+		It("Writes a verified backup", func() {
+			path, err := Maildir("test/Maildir").Backup(dbs["test/Maildir"], 3)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = VerifyBackup(path)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			backups, err := Maildir("test/Maildir").Backups()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(backups).Should(Equal([]string{path}))
+		})
+
+		It("Fails to verify a corrupt backup", func() {
+			err = ioutil.WriteFile("test/Maildir/sisyphus.db.backup.20000101T000000Z", []byte("junk"), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = VerifyBackup("test/Maildir/sisyphus.db.backup.20000101T000000Z")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("Keeps only the newest backups", func() {
+			for _, old := range []string{"20000101T000000Z", "20000102T000000Z"} {
+				err = ioutil.WriteFile("test/Maildir/sisyphus.db.backup."+old, []byte("old"), 0600)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			path, err := Maildir("test/Maildir").Backup(dbs["test/Maildir"], 2)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			backups, err := Maildir("test/Maildir").Backups()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(backups).Should(Equal([]string{
+				path,
+				"test/Maildir/sisyphus.db.backup.20000102T000000Z",
+			}))
+		})
+
+		It("Restores the newest backup", func() {
+			path, err := Maildir("test/Maildir").Backup(dbs["test/Maildir"], 3)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = Maildir("test/Maildir").Restore("")
+			Ω(err).Should(HaveOccurred())
+
+			// the database in use is left as it is
+			_, err = os.Stat("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+			for _, f := range []string{"sisyphus.db.replaced", "sisyphus.db.tmp"} {
+				_, err = os.Stat("test/Maildir/" + f)
+				Ω(os.IsNotExist(err)).Should(BeTrue())
+			}
+
+			CloseDatabases(dbs)
+
+			restored, err := Maildir("test/Maildir").Restore("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(restored).Should(Equal(path))
+
+			_, err = os.Stat("test/Maildir/sisyphus.db.replaced")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+})
@@ -1,23 +1,54 @@
 package sisyphus
 
 import (
+	"encoding/binary"
+	"math"
 	"math/rand"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/boltdb/bolt"
+	"github.com/carlostrub/maildir"
 	"github.com/gonum/stat"
-	"github.com/retailnext/hllpp"
+	bolt "go.etcd.io/bbolt"
 )
 
+// source is a store consulted during classification. Its counts are read
+// from the buckets below prefix, e.g. those of a period, and scaled by weight
+// before they are added to the counts of other sources.
+type source struct {
+	store  Store
+	weight float64
+	prefix string
+}
+
+// sources returns the stores consulted to classify the mail. With a
+// half-life or a retention configured, the Maildir's database is consulted
+// per period.
+func (m *Mail) sources(st Store) (s []source, err error) {
+	s = []source{{store: st, weight: 1}}
+	if decay.periodic() {
+		s, err = periodSources(st, 1, decay, time.Now())
+		if err != nil {
+			return s, err
+		}
+	}
+
+	if m.Shared != nil {
+		s = append(s, source{store: NewBoltStore(m.Shared.DB), weight: m.Shared.Weight})
+	}
+
+	return s, nil
+}
+
 // classificationPrior returns the prior probabilities for good and junk
 // classes.
-func classificationPrior(db *bolt.DB) (g float64, err error) {
+func classificationPrior(sources []source) (g float64, err error) {
 
-	gTotal, jTotal, err := classificationStatistics(db)
+	gTotal, jTotal, err := classificationStatistics(sources)
 	if err != nil {
 		return g, err
 	}
@@ -27,92 +58,76 @@ func classificationPrior(db *bolt.DB) (g float64, err error) {
 
 // classificationLikelihoodWordcounts gets wordcounts from database to be used
 // in Likelihood calculation
-func classificationLikelihoodWordcounts(db *bolt.DB, word string) (gN, jN float64, err error) {
-
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Wordlists"))
+func classificationLikelihoodWordcounts(sources []source, word string) (gN, jN float64, err error) {
 
-		good := b.Bucket([]byte("Good"))
-		gWordRaw := good.Get([]byte(word))
-		if len(gWordRaw) > 0 {
-			var gWordHLL *hllpp.HLLPP
-			gWordHLL, err = hllpp.Unmarshal(gWordRaw)
+	for _, s := range sources {
+		err = s.store.View(func(tx Tx) error {
+			key := tokenKey(tx, word)
+			g, err := countOf(tx, s.prefix+"Wordlists/Good", key)
 			if err != nil {
 				return err
 			}
-			gN = float64(gWordHLL.Count())
-		}
-		junk := b.Bucket([]byte("Junk"))
-		jWordRaw := junk.Get([]byte(word))
-		if len(jWordRaw) > 0 {
-			var jWordHLL *hllpp.HLLPP
-			jWordHLL, err = hllpp.Unmarshal(jWordRaw)
+			j, err := countOf(tx, s.prefix+"Wordlists/Junk", key)
 			if err != nil {
 				return err
 			}
-			jN = float64(jWordHLL.Count())
-		}
 
-		return nil
-	})
+			gN += s.weight * float64(g)
+			jN += s.weight * float64(j)
+
+			return nil
+		})
+		if err != nil {
+			return gN, jN, err
+		}
+	}
 
 	return gN, jN, err
 }
 
 // classificationStatistics gets global statistics from database to
 // be used in Likelihood calculation
-func classificationStatistics(db *bolt.DB) (gTotal, jTotal float64, err error) {
-
-	err = db.View(func(tx *bolt.Tx) error {
-		p := tx.Bucket([]byte("Statistics"))
-		gRaw := p.Get([]byte("ProcessedGood"))
-		if len(gRaw) > 0 {
-			var gHLL *hllpp.HLLPP
-			gHLL, err = hllpp.Unmarshal(gRaw)
-			if err != nil {
-				return err
-			}
-			gTotal = float64(gHLL.Count())
-		}
-		jRaw := p.Get([]byte("ProcessedJunk"))
-		if len(jRaw) > 0 {
-			var jHLL *hllpp.HLLPP
-			jHLL, err = hllpp.Unmarshal(jRaw)
+func classificationStatistics(sources []source) (gTotal, jTotal float64, err error) {
+
+	for _, s := range sources {
+		err = s.store.View(func(tx Tx) error {
+			g, j, err := mailCounts(tx, s.prefix)
 			if err != nil {
 				return err
 			}
-			jTotal = float64(jHLL.Count())
-		}
 
-		if gTotal == 0 && jTotal == 0 {
-			log.Warning("no mails have yet been learned")
-			return nil
-		}
-		if gTotal == 0 {
-			log.Warning("no good mails have yet been learned")
-			return nil
-		}
-		if jTotal == 0 {
-			log.Warning("no junk mails have yet been learned")
+			gTotal += s.weight * float64(g)
+			jTotal += s.weight * float64(j)
+
 			return nil
+		})
+		if err != nil {
+			return gTotal, jTotal, err
 		}
+	}
 
-		return nil
-	})
+	switch {
+	case gTotal == 0 && jTotal == 0:
+		log.Warning("no mails have yet been learned")
+	case gTotal == 0:
+		log.Warning("no good mails have yet been learned")
+	case jTotal == 0:
+		log.Warning("no junk mails have yet been learned")
+	}
 
 	return gTotal, jTotal, err
 }
 
 // classificationLikelihood returns P(W|C_j) -- the probability of seeing a
 // particular word W in a document of this class.
-func classificationLikelihood(db *bolt.DB, word string) (g, j float64, err error) {
+func classificationLikelihood(sources []source, word string) (g, j float64, err error) {
 
-	gN, jN, err := classificationLikelihoodWordcounts(db, word)
+	gN, jN, err := classificationLikelihoodWordcounts(sources, word)
 	if err != nil {
 		return g, j, err
 	}
 
-	gTotal, jTotal, err := classificationStatistics(db)
+	gTotal, jTotal, err := classificationStatistics(sources)
 	if err != nil {
 		return g, j, err
 	}
@@ -125,14 +140,14 @@ func classificationLikelihood(db *bolt.DB, word string) (g, j float64, err error
 
 // classificationWord produces the conditional probability of a word belonging
 // to good or junk using the classic Bayes' rule.
-func classificationWord(db *bolt.DB, word string) (g float64, err error) {
+func classificationWord(sources []source, word string) (g float64, err error) {
 
-	priorG, err := classificationPrior(db)
+	priorG, err := classificationPrior(sources)
 	if err != nil {
 		return g, err
 	}
 
-	likelihoodG, likelihoodJ, err := classificationLikelihood(db, word)
+	likelihoodG, likelihoodJ, err := classificationLikelihood(sources, word)
 	if err != nil {
 		return g, err
 	}
@@ -142,45 +157,170 @@ func classificationWord(db *bolt.DB, word string) (g float64, err error) {
 	return g, nil
 }
 
+// recentScores is the number of classification scores kept for statistics
+const recentScores = 1000
+
+// recordScore keeps the junk probability of a classified mail, so the
+// distribution of recent scores can be reported. Only the most recent scores
+// are kept.
+func recordScore(s Store, prob float64) error {
+	return s.Update(func(tx Tx) error {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(time.Now().UnixNano()))
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, math.Float64bits(prob))
+
+		err := tx.Put("Scores", string(key), value)
+		if err != nil {
+			return err
+		}
+
+		// keys sort by time, so drop from the front
+		var old []string
+		n := tx.Len("Scores") - recentScores
+		err = tx.ForEach("Scores", func(k string, _ []byte) error {
+			if len(old) < n {
+				old = append(old, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range old {
+			err = tx.Delete("Scores", k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// scoreHistogram returns the number of recently classified mails in ten
+// equally wide bins of junk probability. Mails that could not be classified
+// for lack of information are not counted.
+func scoreHistogram(tx *bolt.Tx) (histogram []uint64, err error) {
+	histogram = make([]uint64, 10)
+
+	b := tx.Bucket([]byte("Scores"))
+	if b == nil {
+		return histogram, nil
+	}
+
+	err = b.ForEach(func(_, v []byte) error {
+		prob := math.Float64frombits(binary.BigEndian.Uint64(v))
+		if math.IsNaN(prob) {
+			return nil
+		}
+
+		bin := int(math.Min(prob*10, 9))
+		histogram[bin]++
+
+		return nil
+	})
+
+	return histogram, err
+}
+
+// judge decides whether a loaded mail with the given header is junk. The
+// sender lists of the Maildir decide before the words of the mail are
+// considered; listed tells their verdict, and prob is NaN if they decided.
+func (m *Mail) judge(s Store, d Maildir, header *mail.Header) (junk bool, prob float64, listed string, err error) {
+	prob = math.NaN()
+
+	listed, err = d.senderVerdict(s, sender(header))
+	if err != nil || listed != SenderUnknown {
+		return listed == SenderBlocked, prob, listed, err
+	}
+
+	t, err := storeTokenizer(s)
+	if err != nil {
+		return junk, prob, listed, err
+	}
+
+	list, err := m.cleanWordlist(t)
+	if err != nil {
+		return junk, prob, listed, err
+	}
+
+	sources, err := m.sources(s)
+	if err != nil {
+		return junk, prob, listed, err
+	}
+
+	junk, prob, err = junkSources(sources, list)
+
+	return junk, prob, listed, err
+}
+
 // Classify analyses a new mail (a mail that arrived in the "new" directory),
 // decides whether it is junk and -- if so -- moves it to the Junk folder. If
 // it is not junk, the mail is untouched so it can be handled by the mail
-// client.
+// client. Mails from senders on the Maildir's allow or block lists are
+// decided without looking at their words.
 func (m *Mail) Classify(db *bolt.DB, dir Maildir) (err error) {
+	return m.ClassifyStore(NewBoltStore(db), dir)
+}
 
+// ClassifyStore works like Classify, but consults and records into any Store.
+func (m *Mail) ClassifyStore(s Store, dir Maildir) (err error) {
+
+	start := time.Now()
 	m.New = true
+	folder := m.folder(dir)
 
-	err = m.Load(dir)
+	rescued, err := isRescued(s, m.Key)
+	if err != nil || rescued {
+		return err
+	}
+
+	header, err := maildir.Dir(folder).Header(m.Key)
 	if err != nil {
 		return err
 	}
 
-	list, err := m.cleanWordlist()
+	err = m.Load(dir)
 	if err != nil {
 		return err
 	}
 
-	junk, prob, err := Junk(db, list)
+	junk, prob, listed, err := m.judge(s, dir, header)
 	if err != nil {
 		return err
 	}
 
+	if listed == SenderUnknown {
+		err = recordScore(s, prob)
+		if err != nil {
+			return err
+		}
+	}
+
 	m.Junk = junk
 
+	entry := m.newHistoryEntry(header, prob)
+	entry.Sender = listed
+
 	log.WithFields(log.Fields{
 		"mail":        m.Key,
 		"junk":        m.Junk,
 		"probability": prob,
+		"sender":      listed,
 		"dir":         string(dir),
 	}).Info("Classified")
 
 	// Move mail around if junk.
 	if junk {
+		entry.Action = ActionDryRun
 		if !m.DryRun {
-			err = os.Rename(filepath.Join(string(dir), "new", m.Key), filepath.Join(string(dir), ".Junk", "cur", m.Key))
+			err = os.Rename(filepath.Join(string(dir), "new", m.Key), filepath.Join(string(dir), layout.Junk, "cur", m.Key))
 			if err != nil {
 				return err
 			}
+			entry.Action = ActionMoved
 		}
 
 		var dryRun string
@@ -193,6 +333,18 @@ func (m *Mail) Classify(db *bolt.DB, dir Maildir) (err error) {
 		}).Info("Moved to Junk folder" + dryRun)
 	}
 
+	err = recordHistory(s, entry)
+	if err != nil {
+		return err
+	}
+
+	verdict := "good"
+	if junk {
+		verdict = "junk"
+	}
+	mailsClassified.WithLabelValues(string(dir), verdict).Inc()
+	classifyDuration.WithLabelValues(string(dir)).Observe(time.Since(start).Seconds())
+
 	err = m.Unload(dir)
 
 	return err
@@ -202,6 +354,25 @@ func (m *Mail) Classify(db *bolt.DB, dir Maildir) (err error) {
 // rule. If required, it also returns the calculated probability of being junk,
 // but this is typically not needed.
 func Junk(db *bolt.DB, wordlist []string) (junk bool, prob float64, err error) {
+	return JunkStore(NewBoltStore(db), wordlist)
+}
+
+// JunkStore works like Junk, but reads the learned model from any Store.
+func JunkStore(s Store, wordlist []string) (junk bool, prob float64, err error) {
+	return junkSources([]source{{store: s, weight: 1}}, wordlist)
+}
+
+// JunkShared works like Junk, but blends the counts of a shared database into
+// the counts of the Maildir's database.
+func JunkShared(db *bolt.DB, shared Shared, wordlist []string) (junk bool, prob float64, err error) {
+	return junkSources([]source{
+		{store: NewBoltStore(db), weight: 1},
+		{store: NewBoltStore(shared.DB), weight: shared.Weight},
+	}, wordlist)
+}
+
+// junkSources classifies the wordlist based on the counts of all sources.
+func junkSources(sources []source, wordlist []string) (junk bool, prob float64, err error) {
 	var probabilities []float64
 
 	// If the wordlist is too long, let us only select a random sample
@@ -229,7 +400,7 @@ func Junk(db *bolt.DB, wordlist []string) (junk bool, prob float64, err error) {
 
 	for _, val := range wordlist {
 		var p float64
-		p, err = classificationWord(db, val)
+		p, err = classificationWord(sources, val)
 		if err != nil {
 			return false, 0.0, err
 		}
@@ -0,0 +1,177 @@
+package sisyphus
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactTxSize is the number of bytes copied per transaction while
+// compacting
+const compactTxSize = 1 << 20
+
+// Compaction tells the size of a database file before and after it was
+// compacted.
+type Compaction struct {
+	Before int64 `json:"before"`
+	After  int64 `json:"after"`
+}
+
+// compactDB writes a copy of db without free pages to path and verifies it.
+func compactDB(db *bolt.DB, path string) error {
+	os.Remove(path)
+
+	dst, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	err = bolt.Compact(dst, db, compactTxSize)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = VerifyBackup(path)
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+
+	return err
+}
+
+// fileSize returns the size of the file at path
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// Free returns the fraction of the database file taken by free pages, which
+// Compact gives back to the file system.
+func (h *Handle) Free() (free float64, err error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	err = h.db.View(func(tx *bolt.Tx) error {
+		if size := tx.Size(); size > 0 {
+			free = float64(h.db.Stats().FreeAlloc) / float64(size)
+		}
+		return nil
+	})
+
+	return free, err
+}
+
+// Compact rewrites the database into a new file without free pages and
+// replaces the file in use by it. Database files never shrink otherwise,
+// even if most of their content was deleted. Users of the Handle wait until
+// the database is compacted.
+func (h *Handle) Compact() (c Compaction, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	path := h.db.Path()
+	c.Before, err = fileSize(path)
+	if err != nil {
+		return c, err
+	}
+
+	tmp := path + ".compact"
+	err = compactDB(h.db, tmp)
+	if err != nil {
+		return c, err
+	}
+
+	err = h.swap(tmp)
+	if err != nil {
+		return c, err
+	}
+
+	c.After, err = fileSize(path)
+	if err != nil {
+		return c, err
+	}
+
+	log.WithFields(log.Fields{
+		"db":     path,
+		"before": c.Before,
+		"after":  c.After,
+	}).Info("Database compacted")
+
+	return c, nil
+}
+
+// scrub compacts an open database after its words were hashed or its values
+// encrypted, so the words and values replaced do not remain in free pages of
+// the file, and opens the compacted file instead. Backups made before are
+// left alone, so the user is told to remove them. On error, the closed
+// database may be returned.
+func scrub(db *bolt.DB) (*bolt.DB, error) {
+	path := db.Path()
+	tmp := path + ".compact"
+
+	before, err := fileSize(path)
+	if err != nil {
+		return db, err
+	}
+
+	err = compactDB(db, tmp)
+	if err != nil {
+		return db, err
+	}
+
+	err = db.Close()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return db, err
+	}
+
+	compacted, err := bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return db, err
+	}
+
+	after, _ := fileSize(path)
+	log.WithFields(log.Fields{
+		"db":     path,
+		"before": before,
+		"after":  after,
+	}).Info("Database compacted to drop the replaced values")
+
+	backups, _ := Maildir(filepath.Dir(path)).Backups()
+	if len(backups) > 0 {
+		log.WithFields(log.Fields{
+			"db":      path,
+			"backups": len(backups),
+		}).Warn("Older backups still hold the replaced values, remove them once a new backup has been made")
+	}
+
+	return compacted, nil
+}
+
+// CompactDatabase compacts the database at path, see Handle.Compact. If
+// path is a directory, the sisyphus.db within is used. The database must not
+// be in use, so it has to be compacted by the daemon if it is running.
+func CompactDatabase(path string) (c Compaction, err error) {
+
+	path = dbPath(path)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return c, err
+	}
+	h := NewHandle(db)
+	defer h.Close()
+
+	return h.Compact()
+}
@@ -0,0 +1,101 @@
+package sisyphus_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compact", func() {
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		// learn into two periods and drop one of them again, so the
+		// file holds free pages
+		err = SetDecay(Decay{HalfLife: 30 * 24 * time.Hour})
+		Ω(err).ShouldNot(HaveOccurred())
+		defer SetDecay(Decay{})
+
+		m = &Mail{
+			Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+			Junk: true,
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key:      "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			Received: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = Expire(dbs["test/Maildir"], time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Compacts a database that is not in use", func() {
+		_, _, gWords, jWords := Info(dbs["test/Maildir"])
+		CloseDatabases(dbs)
+
+		c, err := CompactDatabase("test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(c.After).Should(BeNumerically("<", c.Before))
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		gTotal, jTotal, g, j := Info(dbs["test/Maildir"])
+		Ω(gTotal).Should(Equal(uint64(1)))
+		Ω(jTotal).Should(Equal(uint64(1)))
+		Ω(g).Should(Equal(gWords))
+		Ω(j).Should(Equal(jWords))
+	})
+
+	It("Refuses to compact a database in use", func() {
+		_, err := CompactDatabase("test/Maildir")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Compacts a database in use by a running daemon", func() {
+		h := NewHandle(dbs["test/Maildir"])
+		defer h.Close()
+
+		free, err := h.Free()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(free).Should(BeNumerically(">", 0))
+
+		l, err := Maildir("test/Maildir").Serve(h)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer l.Close()
+
+		c, err := Maildir("test/Maildir").LiveCompact()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(c.After).Should(BeNumerically("<", c.Before))
+
+		// the handle uses the compacted database from now on
+		err = h.Use(func(db *bolt.DB) error {
+			gTotal, jTotal, _, _ := Info(db)
+			Ω(gTotal).Should(Equal(uint64(1)))
+			Ω(jTotal).Should(Equal(uint64(1)))
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+})
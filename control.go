@@ -0,0 +1,272 @@
+package sisyphus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNoDaemon is returned by control requests if no daemon serves the
+// Maildir.
+var ErrNoDaemon = errors.New("no daemon is running")
+
+// socket returns the path of the control socket of a Maildir
+func (d Maildir) socket() string {
+	return filepath.Join(string(d), "sisyphus.sock")
+}
+
+// Serve answers requests of other sisyphus processes, e.g. for live
+// statistics, on a unix socket in the Maildir. This lets them read from a
+// database that is locked by the running daemon. Only the owner of the
+// daemon may connect, as requests change the database. Requests are served
+// in the background until the returned listener is closed.
+func (d Maildir) Serve(h *Handle) (l net.Listener, err error) {
+
+	// A socket left behind by a previous daemon prevents listening
+	os.Remove(d.socket())
+
+	l, err = net.Listen("unix", d.socket())
+	if err != nil {
+		return l, err
+	}
+	err = os.Chmod(d.socket(), 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		var s Statistics
+		err := h.Use(func(db *bolt.DB) (err error) {
+			s, err = d.Stats(db)
+			return err
+		})
+		reply(w, s, err)
+	})
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		var f HistoryFilter
+		err := json.NewDecoder(r.Body).Decode(&f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var entries []HistoryEntry
+		err = h.Use(func(db *bolt.DB) (err error) {
+			entries, err = History(db, f)
+			return err
+		})
+		reply(w, entries, err)
+	})
+	mux.HandleFunc("/expire", func(w http.ResponseWriter, r *http.Request) {
+		var before time.Time
+		err := json.NewDecoder(r.Body).Decode(&before)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var dropped []string
+		err = h.Use(func(db *bolt.DB) (err error) {
+			dropped, err = Expire(db, before)
+			return err
+		})
+		reply(w, dropped, err)
+	})
+	mux.HandleFunc("/rescue", func(w http.ResponseWriter, r *http.Request) {
+		var req RescueRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var rescued []string
+		err = h.Use(func(db *bolt.DB) (err error) {
+			rescued, err = d.Rescue(db, req)
+			return err
+		})
+		reply(w, rescued, err)
+	})
+	mux.HandleFunc("/fsck", func(w http.ResponseWriter, r *http.Request) {
+		var req FsckRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var report FsckReport
+		err = h.Use(func(db *bolt.DB) (err error) {
+			report, err = d.Fsck(db, req)
+			return err
+		})
+		reply(w, report, err)
+	})
+	mux.HandleFunc("/swap-rebuild", func(w http.ResponseWriter, r *http.Request) {
+		err := d.SwapRebuild(h)
+		reply(w, err == nil, err)
+	})
+	mux.HandleFunc("/explain", func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		err := json.NewDecoder(r.Body).Decode(&key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var tokens []Token
+		err = h.Use(func(db *bolt.DB) (err error) {
+			tokens, err = d.Explain(db, key)
+			return err
+		})
+		reply(w, tokens, err)
+	})
+	mux.HandleFunc("/compact", func(w http.ResponseWriter, r *http.Request) {
+		c, err := h.Compact()
+		reply(w, c, err)
+	})
+
+	log.WithFields(log.Fields{
+		"socket": d.socket(),
+	}).Info("Listening for control requests")
+
+	go http.Serve(l, mux)
+
+	return l, nil
+}
+
+// client returns a HTTP client talking to the control socket of a Maildir
+func (d Maildir) client() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", d.socket())
+			},
+		},
+	}
+}
+
+// reply sends the result of a control request as JSON
+func reply(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warning("Cannot answer control request")
+	}
+}
+
+// call sends a control request with the JSON encoded argument to the daemon
+// serving the Maildir and decodes the answer into result. It returns
+// ErrNoDaemon if no daemon is running.
+func (d Maildir) call(path string, argument, result interface{}) error {
+
+	body, err := json.Marshal(argument)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client().Post("http://sisyphus"+path, "application/json", bytes.NewReader(body))
+	var dial *net.OpError
+	if errors.As(err, &dial) && dial.Op == "dial" {
+		return ErrNoDaemon
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("daemon answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// LiveStats asks the daemon serving the Maildir for the current statistics
+// of its database.
+func (d Maildir) LiveStats() (s Statistics, err error) {
+	err = d.call("/stats", nil, &s)
+
+	return s, err
+}
+
+// LiveHistory asks the daemon serving the Maildir for the classification
+// history selected by the filter.
+func (d Maildir) LiveHistory(f HistoryFilter) (entries []HistoryEntry, err error) {
+	err = d.call("/history", f, &entries)
+
+	return entries, err
+}
+
+// LiveExpire asks the daemon serving the Maildir to drop the periods of its
+// database that ended before the given time, see Expire.
+func (d Maildir) LiveExpire(before time.Time) (dropped []string, err error) {
+	err = d.call("/expire", before, &dropped)
+
+	return dropped, err
+}
+
+// LiveRescue asks the daemon serving the Maildir to rescue mails from the
+// Junk folder, see Rescue.
+func (d Maildir) LiveRescue(r RescueRequest) (rescued []string, err error) {
+	err = d.call("/rescue", r, &rescued)
+
+	return rescued, err
+}
+
+// LiveCompact asks the daemon serving the Maildir to compact its database,
+// see Handle.Compact.
+func (d Maildir) LiveCompact() (c Compaction, err error) {
+	err = d.call("/compact", nil, &c)
+
+	return c, err
+}
+
+// LiveFsck asks the daemon serving the Maildir to check its database, see
+// Fsck.
+func (d Maildir) LiveFsck(r FsckRequest) (report FsckReport, err error) {
+	err = d.call("/fsck", r, &report)
+
+	return report, err
+}
+
+// LiveSwapRebuild asks the daemon serving the Maildir to swap in the
+// database trained with Rebuild, see SwapRebuild.
+func (d Maildir) LiveSwapRebuild() error {
+	var done bool
+
+	return d.call("/swap-rebuild", nil, &done)
+}
+
+// LiveExplain asks the daemon serving the Maildir how the words of a mail
+// weigh in its classification, see Explain.
+func (d Maildir) LiveExplain(key string) (tokens []Token, err error) {
+	err = d.call("/explain", key, &tokens)
+
+	return tokens, err
+}
@@ -0,0 +1,69 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Control", func() {
+
+	Context("Live statistics", func() {
+		BeforeEach(func() {
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:  "1488226337.M327822P8269.mail.carlostrub.ch,S=3620,W=3730",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Fetches statistics from a running daemon", func() {
+			l, err := Maildir("test/Maildir").Serve(NewHandle(dbs["test/Maildir"]))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer l.Close()
+
+			s, err := Maildir("test/Maildir").LiveStats()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.JunkMails).Should(Equal(uint64(1)))
+			Ω(s.JunkWords).Should(Equal(uint64(27)))
+		})
+
+		It("Lets only the owner connect", func() {
+			l, err := Maildir("test/Maildir").Serve(NewHandle(dbs["test/Maildir"]))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer l.Close()
+
+			info, err := os.Stat("test/Maildir/sisyphus.sock")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.Mode().Perm()).Should(Equal(os.FileMode(0600)))
+		})
+
+		It("Fetches the history from a running daemon", func() {
+			l, err := Maildir("test/Maildir").Serve(NewHandle(dbs["test/Maildir"]))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer l.Close()
+
+			entries, err := Maildir("test/Maildir").LiveHistory(HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(BeEmpty())
+		})
+
+		It("Fails if no daemon is running", func() {
+			_, err := Maildir("test/Maildir").LiveStats()
+			Ω(err).Should(Equal(ErrNoDaemon))
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package sisyphus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Progress reports how far learning a corpus has come: the number of mails
+// learned so far and the fraction of the corpus read.
+type Progress func(mails int, done float64)
+
+// corpusMail returns the loaded mail of a message from a corpus. Its key is
+// the Message-ID or, lacking one, a hash of the raw message, and it was
+// received at its Date.
+func corpusMail(message *mail.Message, raw []byte) (m *Mail, err error) {
+	m = &Mail{Key: message.Header.Get("Message-Id")}
+	if m.Key == "" {
+		sum := sha256.Sum256(raw)
+		m.Key = hex.EncodeToString(sum[:])
+	}
+	if date, err := message.Header.Date(); err == nil {
+		m.Received = date
+	}
+
+	return m, m.parse(message)
+}
+
+// corpusFiles returns the mail files of a directory. Of a Maildir, only the
+// mails in cur and new are used, otherwise all files below the directory
+// except hidden ones.
+func corpusFiles(dir string) (files []string, err error) {
+	roots := []string{dir}
+	if info, err := os.Stat(filepath.Join(dir, "cur")); err == nil && info.IsDir() {
+		roots = []string{filepath.Join(dir, "cur"), filepath.Join(dir, "new")}
+	}
+
+	for _, root := range roots {
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case os.IsNotExist(err) && path == root:
+				return nil
+			case err != nil:
+				return err
+			case path != root && strings.HasPrefix(info.Name(), "."):
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			case info.Mode().IsRegular():
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return files, err
+		}
+	}
+
+	return files, nil
+}
+
+// LearnDir learns all mails in a directory, e.g. a Maildir or an unpacked
+// public corpus, as good or junk without touching them. It reports its
+// progress if progress is not nil and returns the number of mails learned.
+// Files that cannot be parsed are skipped.
+func LearnDir(db *bolt.DB, dir string, junk bool, progress Progress) (n int, err error) {
+	files, err := corpusFiles(dir)
+	if err != nil {
+		return n, err
+	}
+
+	for i, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			return n, err
+		}
+
+		message, err := mail.ReadMessage(bytes.NewReader(raw))
+		var m *Mail
+		if err == nil {
+			m, err = corpusMail(message, raw)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": f,
+			}).Warning("Cannot read mail")
+			continue
+		}
+		m.Junk = junk
+
+		err = m.learn(NewBoltStore(db), dir)
+		if err != nil {
+			return n, err
+		}
+		n++
+
+		if progress != nil {
+			progress(n, float64(i+1)/float64(len(files)))
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"dir":   dir,
+		"mails": n,
+		"junk":  junk,
+	}).Info("Directory learned")
+
+	return n, nil
+}
+
+// LearnCorpus learns all mails of a directory or an mbox file as good or
+// junk, see LearnDir and LearnMbox.
+func LearnCorpus(db *bolt.DB, path string, junk bool, progress Progress) (n int, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return n, err
+	}
+
+	if info.IsDir() {
+		return LearnDir(db, path, junk, progress)
+	}
+
+	return LearnMbox(db, path, junk, progress)
+}
@@ -0,0 +1,71 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Corpus", func() {
+
+	Context("Learn a corpus once", func() {
+		BeforeEach(func() {
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Learns the mails of a Maildir and reports progress", func() {
+			var mails int
+			var done float64
+			n, err := LearnCorpus(dbs["test/Maildir"], "test/Maildir/.Junk", true, func(m int, d float64) {
+				mails, done = m, d
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(10))
+			Ω(mails).Should(Equal(10))
+			Ω(done).Should(Equal(1.0))
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.JunkMails).Should(Equal(uint64(10)))
+		})
+
+		It("Learns all but hidden files of a plain directory", func() {
+			dir, err := ioutil.TempDir("", "sisyphus")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			raw, err := ioutil.ReadFile("test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			for _, f := range []string{"1", "2", ".hidden"} {
+				err = ioutil.WriteFile(filepath.Join(dir, f), raw, 0600)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			n, err := LearnCorpus(dbs["test/Maildir"], dir, false, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(2))
+
+			// both files hold the same mail
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.GoodMails).Should(Equal(uint64(1)))
+		})
+
+		It("Fails on missing corpora", func() {
+			_, err := LearnCorpus(dbs["test/Maildir"], "test/DOESNOTEXIST", false, nil)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
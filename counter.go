@@ -0,0 +1,412 @@
+package sisyphus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/retailnext/hllpp"
+)
+
+const (
+	// CountHLL counts mails with a HyperLogLog sketch of their keys per
+	// word. The keys of the mails learned are kept, so learning a mail
+	// twice does not count it twice, but counts of few mails are
+	// approximate and mails cannot be unlearned.
+	CountHLL = "hll"
+
+	// CountExact counts mails exactly per word. The keys of the mails
+	// learned are kept, so learning a mail twice does not count it twice
+	// and mails can be unlearned.
+	CountExact = "exact"
+
+	// CountMin counts mails per word in a count-min sketch of fixed size,
+	// whose counts may be too high but never too low. Like CountExact, it
+	// keeps the keys of the mails learned. The words themselves are not
+	// stored, so statistics cannot list them.
+	CountMin = "cms"
+)
+
+// Counting selects how a database counts the mails each word has been
+// learned from. Precision is the precision of HyperLogLog sketches, from 4
+// to 16; Depth and Width are the number of rows of a count-min sketch and
+// of counters in each row.
+type Counting struct {
+	Model     string
+	Precision uint8
+	Depth     int
+	Width     int
+}
+
+// legacyCounting is the counting of databases created before the counting
+// model was selectable
+var legacyCounting = Counting{Model: CountHLL, Precision: 14}
+
+// counting is the Counting of databases created from now on
+var counting = legacyCounting
+
+// SetCounting sets the Counting of databases created from now on. Existing
+// databases keep counting the way they were created with; rebuild them to
+// change it.
+func SetCounting(c Counting) error {
+	_, err := ParseCounting(c.String())
+	if err != nil {
+		return err
+	}
+
+	counting = c
+
+	return nil
+}
+
+// String returns the Counting in the form read by ParseCounting
+func (c Counting) String() string {
+	switch c.Model {
+	case CountHLL:
+		return fmt.Sprintf("%s:%d", c.Model, c.Precision)
+	case CountMin:
+		return fmt.Sprintf("%s:%dx%d", c.Model, c.Depth, c.Width)
+	}
+
+	return c.Model
+}
+
+// ParseCounting reads a Counting: hll with an optional precision, e.g.
+// hll:12, exact, or cms with an optional size, e.g. cms:4x16384. The
+// precision defaults to 14 and the size to 4 rows of 16384 counters.
+func ParseCounting(s string) (c Counting, err error) {
+	model := strings.SplitN(s, ":", 2)
+	c.Model = model[0]
+
+	switch c.Model {
+	case CountHLL:
+		c.Precision = 14
+		if len(model) == 2 {
+			p, err := strconv.ParseUint(model[1], 10, 8)
+			if err != nil || p < 4 || p > 16 {
+				return c, errors.New("precision must be between 4 and 16")
+			}
+			c.Precision = uint8(p)
+		}
+	case CountExact:
+		if len(model) == 2 {
+			return c, errors.New("exact counting takes no parameters")
+		}
+	case CountMin:
+		c.Depth, c.Width = 4, 16384
+		if len(model) == 2 {
+			size := strings.SplitN(model[1], "x", 2)
+			if len(size) != 2 {
+				return c, errors.New("size must be given as rows x counters, e.g. 4x16384")
+			}
+			c.Depth, err = strconv.Atoi(size[0])
+			if err == nil {
+				c.Width, err = strconv.Atoi(size[1])
+			}
+			if err != nil || c.Depth < 1 || c.Width < 1 {
+				return c, errors.New("size must be given as rows x counters, e.g. 4x16384")
+			}
+		}
+	default:
+		return c, fmt.Errorf("unknown counting model %q", c.Model)
+	}
+
+	return c, nil
+}
+
+// countingOf returns the Counting of a store
+func countingOf(tx Tx) (Counting, error) {
+	raw := tx.Get("Meta", "Counter")
+	if len(raw) == 0 {
+		return legacyCounting, nil
+	}
+
+	return ParseCounting(string(raw))
+}
+
+// identities reports whether mails can be taken out of the counts again, so
+// mails can be unlearned and are no longer counted in the class they left
+func (c Counting) identities() bool {
+	return c.Model != CountHLL
+}
+
+// errCannotUnlearn is returned when unlearning from HyperLogLog sketches
+var errCannotUnlearn = errors.New("mails cannot be unlearned from HyperLogLog sketches")
+
+// errNotCounter is the problem of a value that is not a counter
+var errNotCounter = errors.New("not a counter")
+
+// counter reads and updates the counts of a bucket of the learned model
+type counter interface {
+	// count returns the number of mails a key has been learned from
+	count(tx Tx, bucket, key string) (uint64, error)
+
+	// add counts the mails for a key
+	add(tx Tx, bucket, key string, mails []string) error
+
+	// remove uncounts n mails for a key
+	remove(tx Tx, bucket, key string, n uint64) error
+
+	// check validates a value stored in the bucket
+	check(key string, value []byte) error
+
+	// merge returns the value of dst with the value of src added
+	merge(dst, src []byte) ([]byte, error)
+}
+
+// counterOf returns the counter of a bucket. Statistics are counted exactly
+// unless HyperLogLog sketches are used.
+func counterOf(tx Tx, bucket string) (counter, error) {
+	c, err := countingOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c.Model == CountHLL:
+		return hllCounter{precision: c.Precision}, nil
+	case c.Model == CountMin && !strings.HasSuffix(bucket, "Statistics"):
+		return cmsCounter{depth: c.Depth, width: c.Width}, nil
+	}
+
+	return exactCounter{}, nil
+}
+
+// countOf returns the number of mails a key of the learned model has been
+// learned from, or zero if there is none.
+func countOf(tx Tx, bucket, key string) (uint64, error) {
+	c, err := counterOf(tx, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.count(tx, bucket, key)
+}
+
+// addCount counts the mails for a key of the learned model
+func addCount(tx Tx, bucket, key string, mails []string) error {
+	c, err := counterOf(tx, bucket)
+	if err != nil {
+		return err
+	}
+
+	return c.add(tx, bucket, key, mails)
+}
+
+// removeCount uncounts n mails for a key of the learned model
+func removeCount(tx Tx, bucket, key string, n uint64) error {
+	c, err := counterOf(tx, bucket)
+	if err != nil {
+		return err
+	}
+
+	return c.remove(tx, bucket, key, n)
+}
+
+// hllCounter keeps a HyperLogLog sketch of the keys of the mails per key
+type hllCounter struct {
+	precision uint8
+}
+
+func (c hllCounter) count(tx Tx, bucket, key string) (uint64, error) {
+	raw := tx.Get(bucket, key)
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	sketch, err := hllpp.Unmarshal(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	return sketch.Count(), nil
+}
+
+func (c hllCounter) add(tx Tx, bucket, key string, mails []string) (err error) {
+	raw := tx.Get(bucket, key)
+
+	var sketch *hllpp.HLLPP
+	if len(raw) == 0 {
+		sketch, err = hllpp.NewWithConfig(hllpp.Config{Precision: c.precision})
+	} else {
+		sketch, err = hllpp.Unmarshal(raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mails {
+		sketch.Add([]byte(m))
+	}
+
+	return tx.Put(bucket, key, sketch.Marshal())
+}
+
+func (c hllCounter) remove(tx Tx, bucket, key string, n uint64) error {
+	return errCannotUnlearn
+}
+
+func (c hllCounter) check(key string, value []byte) error {
+	_, err := hllpp.Unmarshal(value)
+	if err != nil {
+		return errNotSketch
+	}
+
+	return nil
+}
+
+func (c hllCounter) merge(dst, src []byte) ([]byte, error) {
+	return mergeSketch(dst, src)
+}
+
+// exactCounter keeps the number of mails per key
+type exactCounter struct{}
+
+// decodeCount reads a stored count, where no value counts as zero
+func decodeCount(raw []byte) (uint64, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	if len(raw) != 8 {
+		return 0, errNotCounter
+	}
+
+	return binary.BigEndian.Uint64(raw), nil
+}
+
+// putCount stores a count, deleting keys counting zero
+func putCount(tx Tx, bucket, key string, n uint64) error {
+	if n == 0 {
+		return tx.Delete(bucket, key)
+	}
+
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, n)
+
+	return tx.Put(bucket, key, raw)
+}
+
+func (c exactCounter) count(tx Tx, bucket, key string) (uint64, error) {
+	return decodeCount(tx.Get(bucket, key))
+}
+
+func (c exactCounter) add(tx Tx, bucket, key string, mails []string) error {
+	n, err := decodeCount(tx.Get(bucket, key))
+	if err != nil {
+		return err
+	}
+
+	return putCount(tx, bucket, key, n+uint64(len(mails)))
+}
+
+func (c exactCounter) remove(tx Tx, bucket, key string, n uint64) error {
+	old, err := decodeCount(tx.Get(bucket, key))
+	if err != nil {
+		return err
+	}
+	if n > old {
+		n = old
+	}
+
+	return putCount(tx, bucket, key, old-n)
+}
+
+func (c exactCounter) check(key string, value []byte) error {
+	_, err := decodeCount(value)
+	return err
+}
+
+func (c exactCounter) merge(dst, src []byte) ([]byte, error) {
+	d, err := decodeCount(dst)
+	if err != nil {
+		return nil, err
+	}
+	s, err := decodeCount(src)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, d+s)
+
+	return raw, nil
+}
+
+// cmsCounter keeps a count-min sketch per bucket. Each of its counters is
+// stored under the key row:column.
+type cmsCounter struct {
+	depth, width int
+}
+
+// cells returns the keys of the counters of a key, one per row
+func (c cmsCounter) cells(key string) []string {
+	cells := make([]string, c.depth)
+	for row := range cells {
+		h := fnv.New64a()
+		h.Write([]byte{byte(row), byte(row >> 8)})
+		h.Write([]byte(key))
+		cells[row] = fmt.Sprintf("%d:%d", row, h.Sum64()%uint64(c.width))
+	}
+
+	return cells
+}
+
+func (c cmsCounter) count(tx Tx, bucket, key string) (n uint64, err error) {
+	for i, cell := range c.cells(key) {
+		v, err := decodeCount(tx.Get(bucket, cell))
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || v < n {
+			n = v
+		}
+	}
+
+	return n, nil
+}
+
+func (c cmsCounter) add(tx Tx, bucket, key string, mails []string) error {
+	for _, cell := range c.cells(key) {
+		err := exactCounter{}.add(tx, bucket, cell, mails)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c cmsCounter) remove(tx Tx, bucket, key string, n uint64) error {
+	for _, cell := range c.cells(key) {
+		err := exactCounter{}.remove(tx, bucket, cell, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c cmsCounter) check(key string, value []byte) error {
+	cell := strings.SplitN(key, ":", 2)
+	if len(cell) != 2 {
+		return errors.New("not a counter of the sketch")
+	}
+	row, err := strconv.Atoi(cell[0])
+	if err != nil || row < 0 || row >= c.depth {
+		return errors.New("not a counter of the sketch")
+	}
+	col, err := strconv.Atoi(cell[1])
+	if err != nil || col < 0 || col >= c.width {
+		return errors.New("not a counter of the sketch")
+	}
+
+	return exactCounter{}.check(key, value)
+}
+
+func (c cmsCounter) merge(dst, src []byte) ([]byte, error) {
+	return exactCounter{}.merge(dst, src)
+}
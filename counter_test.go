@@ -0,0 +1,225 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Counting", func() {
+
+	const junkKey = "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa"
+	const goodKey = "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119"
+
+	// use creates the database of the test Maildir counting as given
+	use := func(model string) {
+		c, err := ParseCounting(model)
+		Ω(err).ShouldNot(HaveOccurred())
+		err = SetCounting(c)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	// learn learns the junk and the good mail
+	learn := func() {
+		for _, m := range []*Mail{{Key: junkKey, Junk: true}, {Key: goodKey}} {
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	}
+
+	// london tells whether the learned junk word london is classified
+	london := func() {
+		answer, prob, err := Junk(dbs["test/Maildir"], []string{"london"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(prob).Should(Equal(1.0))
+		Ω(answer).Should(BeTrue())
+	}
+
+	AfterEach(func() {
+		c, _ := ParseCounting("hll")
+		SetCounting(c)
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Parses counting models", func() {
+		use("hll")
+
+		for s, c := range map[string]Counting{
+			"hll":         {Model: CountHLL, Precision: 14},
+			"hll:10":      {Model: CountHLL, Precision: 10},
+			"exact":       {Model: CountExact},
+			"cms":         {Model: CountMin, Depth: 4, Width: 16384},
+			"cms:3x1000":  {Model: CountMin, Depth: 3, Width: 1000},
+			"cms:10x2000": {Model: CountMin, Depth: 10, Width: 2000},
+		} {
+			parsed, err := ParseCounting(s)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(parsed).Should(Equal(c))
+		}
+
+		for _, s := range []string{"hll:20", "exact:1", "cms:4", "cms:0x10", "bloom"} {
+			_, err := ParseCounting(s)
+			Ω(err).Should(HaveOccurred())
+		}
+	})
+
+	It("Keeps the counting model a database was created with", func() {
+		use("exact")
+		learn()
+		CloseDatabases(dbs)
+
+		// databases keep their counting model
+		use("hll:10")
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Counter).Should(Equal("exact"))
+		london()
+
+		m = &Mail{Key: junkKey, Junk: true}
+		err = m.Unlearn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Cannot unlearn from HyperLogLog sketches", func() {
+		use("hll:12")
+		learn()
+		london()
+
+		m = &Mail{Key: junkKey, Junk: true}
+		err = m.Unlearn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Context("Exact counters", func() {
+		BeforeEach(func() {
+			use("exact")
+			learn()
+		})
+
+		It("Counts every mail once", func() {
+			learn()
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.GoodMails).Should(Equal(uint64(1)))
+			Ω(s.JunkMails).Should(Equal(uint64(1)))
+			Ω(s.JunkWords).Should(Equal(uint64(99)))
+			Ω(s.TopJunk[0].Junk).Should(Equal(uint64(1)))
+			london()
+		})
+
+		It("Moves a relearned mail to its new class", func() {
+			m = &Mail{Key: junkKey, Folder: ".Junk"}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			gTotal, jTotal, _, jWords := Info(dbs["test/Maildir"])
+			Ω(gTotal).Should(Equal(uint64(2)))
+			Ω(jTotal).Should(Equal(uint64(0)))
+			Ω(jWords).Should(Equal(uint64(0)))
+		})
+
+		It("Unlearns mails", func() {
+			m = &Mail{Key: junkKey, Junk: true}
+			err = m.Unlearn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			gTotal, jTotal, _, jWords := Info(dbs["test/Maildir"])
+			Ω(gTotal).Should(Equal(uint64(1)))
+			Ω(jTotal).Should(Equal(uint64(0)))
+			Ω(jWords).Should(Equal(uint64(0)))
+
+			_, prob, err := Junk(dbs["test/Maildir"], []string{"london"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(prob).ShouldNot(Equal(1.0))
+		})
+
+		It("Finds nothing in a sound database and rebuilds its statistics", func() {
+			report, err := Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{
+				Repair:            true,
+				RebuildStatistics: true,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report.Problems).Should(BeEmpty())
+			Ω(report.GoodMails).Should(Equal(1))
+			Ω(report.JunkMails).Should(Equal(1))
+			london()
+		})
+
+		It("Refuses to merge mails learned by both databases", func() {
+			src, err := OpenDatabase("test/merged.db")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove("test/merged.db")
+			defer src.Close()
+
+			m = &Mail{Key: junkKey, Junk: true}
+			err = m.Learn(src, "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = Merge(dbs["test/Maildir"], src)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	It("Refuses to merge databases counting differently", func() {
+		use("exact")
+
+		c, err := ParseCounting("hll")
+		Ω(err).ShouldNot(HaveOccurred())
+		err = SetCounting(c)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		src, err := OpenDatabase("test/merged.db")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.Remove("test/merged.db")
+		defer src.Close()
+
+		err = Merge(dbs["test/Maildir"], src)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Context("Count-min sketches", func() {
+		BeforeEach(func() {
+			use("cms:4x1024")
+			learn()
+		})
+
+		It("Classifies with a sketch of fixed size", func() {
+			london()
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.JunkMails).Should(Equal(uint64(1)))
+			Ω(s.JunkWords).Should(BeNumerically("<=", 4*1024))
+			Ω(s.TopJunk).Should(BeEmpty())
+		})
+
+		It("Unlearns mails", func() {
+			m = &Mail{Key: junkKey, Junk: true}
+			err = m.Unlearn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, jTotal, _, jWords := Info(dbs["test/Maildir"])
+			Ω(jTotal).Should(Equal(uint64(0)))
+			Ω(jWords).Should(Equal(uint64(0)))
+		})
+
+		It("Finds nothing in a sound database", func() {
+			report, err := Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report.Problems).Should(BeEmpty())
+		})
+	})
+})
@@ -1,22 +1,184 @@
 package sisyphus
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 )
 
+// lockTimeout is how long to wait for a database held by another process
+const lockTimeout = 10 * time.Second
+
+// ErrDatabaseInUse is returned if a database stays held by another process,
+// usually the daemon, for longer than the lock timeout.
+var ErrDatabaseInUse = errors.New("database is in use, please stop sisyphus first")
+
+// modelBuckets lists the buckets holding the learned model. Nested buckets
+// are separated by a slash.
+var modelBuckets = []string{"Statistics", "Wordlists/Good", "Wordlists/Junk"}
+
+// bucketPath returns the bucket at the given slash separated path, or nil if
+// it does not exist.
+func bucketPath(tx *bolt.Tx, path string) (b *bolt.Bucket) {
+	for i, name := range strings.Split(path, "/") {
+		if i == 0 {
+			b = tx.Bucket([]byte(name))
+		} else {
+			b = b.Bucket([]byte(name))
+		}
+		if b == nil {
+			return nil
+		}
+	}
+
+	return b
+}
+
+// createBucketPath returns the bucket at the given slash separated path and
+// creates it and its parents if required.
+func createBucketPath(tx *bolt.Tx, path string) (b *bolt.Bucket, err error) {
+	for i, name := range strings.Split(path, "/") {
+		if i == 0 {
+			b, err = tx.CreateBucketIfNotExists([]byte(name))
+		} else {
+			b, err = b.CreateBucketIfNotExists([]byte(name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// modelPaths returns the paths of all buckets of the learned model: the
+// all-time buckets and those of every period.
+func modelPaths(tx Tx) (paths []string) {
+	paths = append(paths, modelBuckets...)
+	for _, p := range periods(tx) {
+		for _, path := range modelBuckets {
+			paths = append(paths, periodPrefix(p)+path)
+		}
+	}
+
+	return paths
+}
+
+// learnedPaths returns the paths of all buckets keeping the keys of the
+// mails learned, all-time and per period, see Counting.
+func learnedPaths(tx Tx) (paths []string) {
+	paths = append(paths, "Learned")
+	for _, p := range periods(tx) {
+		paths = append(paths, periodPrefix(p)+"Learned")
+	}
+
+	return paths
+}
+
+// isLearnedPath reports whether path names a bucket keeping the keys of the
+// mails learned
+func isLearnedPath(path string) bool {
+	if path == "Learned" {
+		return true
+	}
+
+	p := strings.SplitN(path, "/", 3)
+	if len(p) != 3 || p[0] != "Periods" || p[2] != "Learned" {
+		return false
+	}
+	_, err := time.Parse(periodFormat, p[1])
+
+	return err == nil
+}
+
+// isModelPath reports whether path names a bucket of the learned model
+func isModelPath(path string) bool {
+	if strings.HasPrefix(path, "Periods/") {
+		p := strings.SplitN(path, "/", 3)
+		if len(p) != 3 {
+			return false
+		}
+		if _, err := time.Parse(periodFormat, p[1]); err != nil {
+			return false
+		}
+		path = p[2]
+	}
+
+	for _, b := range modelBuckets {
+		if path == b {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dbPath returns the path of the database file. If path is a directory, the
+// sisyphus.db within is used.
+func dbPath(path string) string {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		return filepath.Join(path, "sisyphus.db")
+	}
+
+	return path
+}
+
 // openDB creates and opens a new database and its respective buckets (if required)
 func openDB(m Maildir) (db *bolt.DB, err error) {
 
 	log.WithFields(log.Fields{
 		"dir": string(m),
 	}).Info("Loading database")
-	// Open the sisyphus.db data file in your current directory.
-	// It will be created if it doesn't exist.
-	db, err = bolt.Open(filepath.Join(string(m), "sisyphus.db"), 0600, nil)
+
+	return OpenDatabase(filepath.Join(string(m), "sisyphus.db"))
+}
+
+// OpenDatabase opens the database at path and creates it and its buckets if
+// required. If path is a directory, the sisyphus.db within is used. It fails
+// with ErrDatabaseInUse if another process, e.g. the daemon, holds the
+// database for more than ten seconds. From now on, the database learns the
+// features and language set for it, see SetFeatures and SetLanguage. On
+// error, the database is closed again and nil is returned.
+func OpenDatabase(path string) (db *bolt.DB, err error) {
+
+	path = dbPath(path)
+	_, err = os.Stat(path)
+	created := os.IsNotExist(err)
+
+	// Open the sisyphus.db data file. It will be created if it doesn't
+	// exist.
+	db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrDatabaseInUse
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Release the lock of a database that cannot be used
+	defer func() {
+		if err != nil {
+			db.Close()
+			db = nil
+		}
+	}()
+
+	// Refuse databases written by a newer release or encrypted with an
+	// unknown key before touching them
+	err = checkSchema(db)
+	if err == nil {
+		err = checkKey(db)
+	}
+	if err == nil {
+		err = checkTokenSecret(db)
+	}
 	if err != nil {
 		return db, err
 	}
@@ -30,6 +192,42 @@ func openDB(m Maildir) (db *bolt.DB, err error) {
 		return db, err
 	}
 
+	// Create DB bucket for the scores of recently classified mails
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err = tx.CreateBucketIfNotExists([]byte("Scores"))
+		return err
+	})
+	if err != nil {
+		return db, err
+	}
+
+	// Create DB bucket for the history of classification decisions
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err = tx.CreateBucketIfNotExists([]byte("History"))
+		return err
+	})
+	if err != nil {
+		return db, err
+	}
+
+	// Create DB bucket for mails rescued from the Junk folder
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err = tx.CreateBucketIfNotExists([]byte("Rescued"))
+		return err
+	})
+	if err != nil {
+		return db, err
+	}
+
+	// Create DB bucket for automatically allowed senders
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err = tx.CreateBucketIfNotExists([]byte("Allowlist"))
+		return err
+	})
+	if err != nil {
+		return db, err
+	}
+
 	// Create DB bucket for word lists
 	err = db.Update(func(tx *bolt.Tx) error {
 		_, err = tx.CreateBucketIfNotExists([]byte("Wordlists"))
@@ -55,18 +253,87 @@ func openDB(m Maildir) (db *bolt.DB, err error) {
 		_, err = b.CreateBucketIfNotExists([]byte("Good"))
 		return err
 	})
+	if err != nil {
+		return db, err
+	}
+
+	// Stamp new databases, bring existing ones up to date
+	if created {
+		err = initMeta(db)
+	} else {
+		_, err = migrate(db)
+	}
+	if err != nil {
+		return db, err
+	}
+
+	var words, values int
+	if len(tokenSecret) > 0 {
+		words, err = hashTokens(db)
+		if err != nil {
+			return db, err
+		}
+	}
+
+	err = setTokenizer(db, path)
+	if err != nil {
+		return db, err
+	}
+
+	values, err = encryptValues(db)
+	if err != nil {
+		return db, err
+	}
+
+	// The words and values replaced remain in free pages of the file
+	if words > 0 || values > 0 {
+		db, err = scrub(db)
+	}
 
 	return db, err
 }
 
+// OpenDatabaseReadOnly opens an existing database at path without modifying
+// it. Bolt only allows one process to write, so this gives up after timeout
+// with ErrDatabaseInUse if the database is held by another process. If path
+// is a directory, the sisyphus.db within is used. On error, nil is returned.
+func OpenDatabaseReadOnly(path string, timeout time.Duration) (db *bolt.DB, err error) {
+
+	db, err = bolt.Open(dbPath(path), 0600, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  timeout,
+	})
+	if err == bolt.ErrTimeout {
+		return nil, ErrDatabaseInUse
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSchema(db)
+	if err == nil {
+		err = checkKey(db)
+	}
+	if err == nil {
+		err = checkTokenSecret(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
 // LoadDatabases loads all databases from a given slice of Maildirs
 func LoadDatabases(d []Maildir) (databases map[Maildir]*bolt.DB, err error) {
 	databases = make(map[Maildir]*bolt.DB)
 	for _, val := range d {
-		databases[val], err = openDB(val)
+		db, err := openDB(val)
 		if err != nil {
 			return databases, err
 		}
+		databases[val] = db
 	}
 
 	log.Info("All databases loaded")
@@ -74,14 +341,24 @@ func LoadDatabases(d []Maildir) (databases map[Maildir]*bolt.DB, err error) {
 	return databases, nil
 }
 
-// LoadBackupDatabases loads all backup databases from a given slice of Maildirs
+// LoadBackupDatabases loads the newest backup database of each Maildir from
+// a given slice of Maildirs
 func LoadBackupDatabases(d []Maildir) (databases map[Maildir]*bolt.DB, err error) {
 	databases = make(map[Maildir]*bolt.DB)
 	for _, val := range d {
-		databases[val], err = bolt.Open(filepath.Join(string(val), "sisyphus.db.backup"), 0600, nil)
+		backups, err := val.Backups()
+		if err != nil {
+			return databases, err
+		}
+		if len(backups) == 0 {
+			return databases, errors.New("no backup found in " + string(val))
+		}
+
+		db, err := openBackup(backups[0])
 		if err != nil {
 			return databases, err
 		}
+		databases[val] = db
 	}
 
 	log.Info("All databases loaded")
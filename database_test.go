@@ -2,9 +2,10 @@ package sisyphus_test
 
 import (
 	"os"
+	"time"
 
-	"github.com/boltdb/bolt"
 	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -69,5 +70,35 @@ var _ = Describe("Database", func() {
 			Ω(err).Should(HaveOccurred())
 			Ω(n).Should(Equal(4))
 		})
+
+		It("Releases a database it fails to open", func() {
+			c, err := ParseCounting("cms")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = SetCounting(c)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer SetCounting(Counting{Model: CountHLL, Precision: 14})
+
+			err = LoadMaildirs([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			dbs, err := LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			m := &Mail{Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119"}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			CloseDatabases(dbs)
+
+			// the words of a count-min sketch cannot be hashed
+			err = SetTokenSecret([]byte("a secret of thirty-two bytes...."))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer SetTokenSecret(nil)
+
+			db, err := OpenDatabase("test/Maildir")
+			Ω(err).Should(HaveOccurred())
+			Ω(db).Should(BeNil())
+
+			db, err = bolt.Open("test/Maildir/sisyphus.db", 0600, &bolt.Options{Timeout: time.Second})
+			Ω(err).ShouldNot(HaveOccurred())
+			db.Close()
+		})
 	})
 })
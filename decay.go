@@ -0,0 +1,171 @@
+package sisyphus
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// periodFormat names the monthly periods mails are learned into
+const periodFormat = "2006-01"
+
+// Decay configures the time-decayed model. If it is set, mails are learned
+// into the month they arrived in besides the all-time counts. If HalfLife is
+// positive, classification uses these periods instead of the all-time counts
+// and weights each by half for every HalfLife of its age, so recent evidence
+// counts more. Periods older than Retention are dropped by Expire; zero keeps
+// them forever. A Retention alone also makes classification use the periods,
+// all weighted alike, so mails older than Retention no longer count. The
+// all-time counts keep every mail learned; retention only affects
+// classification.
+type Decay struct {
+	HalfLife  time.Duration
+	Retention time.Duration
+}
+
+// periodic reports whether mails are learned into periods, which they are
+// if a half-life or a retention is set.
+func (d Decay) periodic() bool {
+	return d.HalfLife > 0 || d.Retention > 0
+}
+
+// decay is the Decay used for all databases
+var decay Decay
+
+// SetDecay sets the Decay used for all databases.
+func SetDecay(d Decay) error {
+	if d.HalfLife < 0 || d.Retention < 0 {
+		return errors.New("half-life and retention must not be negative")
+	}
+
+	decay = d
+
+	return nil
+}
+
+// period returns the period a mail is learned into: the month it was
+// received, taken from the time stamp that starts a Maildir key if the
+// Received time is unknown.
+func (m *Mail) period() string {
+	t := m.Received
+	if t.IsZero() {
+		sec, err := strconv.ParseInt(strings.SplitN(m.Key, ".", 2)[0], 10, 64)
+		if err == nil {
+			t = time.Unix(sec, 0)
+		}
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	return t.UTC().Format(periodFormat)
+}
+
+// periodPrefix returns the path of the buckets of a period
+func periodPrefix(period string) string {
+	return "Periods/" + period + "/"
+}
+
+// periodEnd returns the end of a period
+func periodEnd(period string) (time.Time, error) {
+	start, err := time.Parse(periodFormat, period)
+	if err != nil {
+		return start, err
+	}
+
+	return start.AddDate(0, 1, 0), nil
+}
+
+// periods returns the periods learned into a store, oldest first
+func periods(tx Tx) []string {
+	return tx.Buckets("Periods")
+}
+
+// Periods returns the periods learned into a database, oldest first
+func Periods(db *bolt.DB) (p []string, err error) {
+	err = NewBoltStore(db).View(func(tx Tx) error {
+		p = periods(tx)
+		return nil
+	})
+
+	return p, err
+}
+
+// periodSources returns a source per period of the store that has not
+// ended before the retention of d at now, weighted by weight and halved for
+// every half-life of d of the period's age.
+func periodSources(store Store, weight float64, d Decay, now time.Time) (s []source, err error) {
+	var p []string
+	err = store.View(func(tx Tx) error {
+		p = periods(tx)
+		return nil
+	})
+	if err != nil {
+		return s, err
+	}
+
+	for _, v := range p {
+		end, err := periodEnd(v)
+		if err != nil {
+			return s, err
+		}
+
+		if d.Retention > 0 && !end.After(now.Add(-d.Retention)) {
+			continue
+		}
+
+		w := weight
+		if d.HalfLife > 0 {
+			age := math.Max(0, now.Sub(end).Hours())
+			w *= math.Pow(0.5, age/d.HalfLife.Hours())
+		}
+		s = append(s, source{
+			store:  store,
+			weight: w,
+			prefix: periodPrefix(v),
+		})
+	}
+
+	return s, nil
+}
+
+// Expire drops the periods of a database that ended before the given time,
+// and returns their names. The all-time counts are kept.
+func Expire(db *bolt.DB, before time.Time) (dropped []string, err error) {
+	err = NewBoltStore(db).Update(func(tx Tx) error {
+		for _, v := range periods(tx) {
+			end, err := periodEnd(v)
+			if err != nil {
+				return err
+			}
+			if end.After(before) {
+				continue
+			}
+
+			err = tx.DeleteBucket("Periods/" + v)
+			if err != nil {
+				return err
+			}
+			dropped = append(dropped, v)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return dropped, err
+	}
+
+	if len(dropped) > 0 {
+		log.WithFields(log.Fields{
+			"periods": strings.Join(dropped, ","),
+		}).Info("Periods expired")
+	}
+
+	return dropped, nil
+}
@@ -0,0 +1,166 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decay", func() {
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		err = SetDecay(Decay{})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	Context("Learn into periods", func() {
+		BeforeEach(func() {
+			err = SetDecay(Decay{HalfLife: 30 * 24 * time.Hour})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Learns a mail into the month it arrived in", func() {
+			p, err := Periods(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p).Should(Equal([]string{"2017-02"}))
+		})
+
+		It("Learns no periods without decay", func() {
+			err = SetDecay(Decay{})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			p, err := Periods(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p).Should(Equal([]string{"2017-02"}))
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.GoodMails).Should(Equal(uint64(1)))
+		})
+
+		It("Drops periods that ended before a time", func() {
+			dropped, err := Expire(dbs["test/Maildir"], time.Date(2017, 2, 15, 0, 0, 0, 0, time.UTC))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(dropped).Should(BeEmpty())
+
+			dropped, err = Expire(dbs["test/Maildir"], time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(dropped).Should(Equal([]string{"2017-02"}))
+
+			p, err := Periods(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p).Should(BeEmpty())
+
+			// the all-time counts remain
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.JunkMails).Should(Equal(uint64(1)))
+		})
+	})
+
+	Context("Classify with a half-life", func() {
+		BeforeEach(func() {
+			err = SetDecay(Decay{HalfLife: 30 * 24 * time.Hour})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			// the same words, learned as good long ago and as junk recently
+			m = &Mail{
+				Key:      "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161",
+				Folder:   ".Junk",
+				Received: time.Now().AddDate(-2, 0, 0),
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:      "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161",
+				Junk:     true,
+				Received: time.Now(),
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = SetDecay(Decay{})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			raw, err := ioutil.ReadFile("test/Maildir/.Junk/cur/1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M8P8.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			err = os.Remove("test/Maildir/new/1500000000.M8P8.test")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Weights recent periods more heavily", func() {
+			m = &Mail{
+				Key:    "1500000000.M8P8.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(m.Junk).Should(BeFalse())
+
+			err = SetDecay(Decay{HalfLife: 30 * 24 * time.Hour})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:    "1500000000.M8P8.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(m.Junk).Should(BeTrue())
+		})
+
+		It("Ignores periods beyond retention", func() {
+			// good counts the words learned as good long ago
+			good := func() (n uint64) {
+				tokens, err := Maildir("test/Maildir").Explain(dbs["test/Maildir"], "1500000000.M8P8.test")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(tokens).ShouldNot(BeEmpty())
+
+				for _, t := range tokens {
+					n += t.Good
+				}
+				return n
+			}
+			Ω(good()).Should(BeNumerically(">", 0))
+
+			err = SetDecay(Decay{Retention: 365 * 24 * time.Hour})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(good()).Should(BeZero())
+		})
+	})
+})
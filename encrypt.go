@@ -0,0 +1,510 @@
+package sisyphus
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// valueMagic prefixes encrypted values. Marshalled HyperLogLog sketches start
+// with their version number, so they never look encrypted.
+var valueMagic = []byte("SYE1")
+
+// fileMagic starts encrypted backup files
+var fileMagic = []byte("SYEF1\n")
+
+// keyIDSize is the number of bytes of the fingerprint identifying a key
+const keyIDSize = 8
+
+// chunkSize is the number of bytes of a backup file sealed at once
+const chunkSize = 64 << 10
+
+var (
+	// ErrKeyRequired is returned when an encrypted database or backup is
+	// opened without any key.
+	ErrKeyRequired = errors.New("database is encrypted, but no key is given")
+
+	// ErrUnknownKey is returned when an encrypted database or backup is
+	// opened without the key it has been encrypted with.
+	ErrUnknownKey = errors.New("database is encrypted with an unknown key")
+)
+
+// key is an encryption key ready for use
+type key struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// keyring holds the encryption keys, the current one first. It is empty if
+// values are not encrypted.
+var keyring []key
+
+// SetEncryptionKeys sets the AES keys the word lists, statistics and history
+// of databases opened from now on, and new backups, are encrypted with. The
+// first key encrypts, while all keys decrypt, so keys can be rotated by
+// putting a new key first and keeping the old ones until no database or
+// backup uses them anymore. Without keys, nothing is encrypted. Values are
+// sealed with a random nonce whenever they change, and a key must not seal
+// more than some 2^32 values. Learning a mail changes a few hundred values,
+// so rotate the key at the latest after a few million mails learned.
+func SetEncryptionKeys(keys [][]byte) error {
+	var ring []key
+	for _, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(k)
+		ring = append(ring, key{id: sum[:keyIDSize], aead: aead})
+	}
+
+	keyring = ring
+
+	return nil
+}
+
+// ParseKeys reads AES keys of 16, 24 or 32 bytes, hex or base64 encoded and
+// separated by commas or white space. Lines starting with # are skipped, so
+// a key file may carry comments.
+func ParseKeys(s string) (keys [][]byte, err error) {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		}) {
+			k, err := hex.DecodeString(field)
+			if err != nil {
+				k, err = base64.StdEncoding.DecodeString(field)
+			}
+			if err != nil {
+				return keys, errors.New("key is neither hex nor base64 encoded")
+			}
+
+			switch len(k) {
+			case 16, 24, 32:
+			default:
+				return keys, fmt.Errorf("key has %d bytes, instead of 16, 24 or 32", len(k))
+			}
+
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
+
+// findKey returns the key of the keyring with the given id, or nil
+func findKey(id []byte) *key {
+	for i := range keyring {
+		if bytes.Equal(keyring[i].id, id) {
+			return &keyring[i]
+		}
+	}
+
+	return nil
+}
+
+// keyError returns why a database or backup encrypted with the key with
+// the given id cannot be read, or nil if it can.
+func keyError(id []byte) error {
+	if findKey(id) != nil {
+		return nil
+	}
+	if len(keyring) == 0 {
+		return ErrKeyRequired
+	}
+
+	return ErrUnknownKey
+}
+
+// sealedPath reports whether the values of the bucket at path are encrypted:
+// those of the learned model, and the history, which names the senders and
+// message ids of the mails classified.
+func sealedPath(path string) bool {
+	return isModelPath(path) || path == "History"
+}
+
+// encrypted reports whether a value has been encrypted
+func encrypted(value []byte) bool {
+	return bytes.HasPrefix(value, valueMagic)
+}
+
+// valueData returns the additional data authenticated with a value, so an
+// encrypted value cannot be moved to another bucket or key unnoticed.
+func valueData(bucket, k string) []byte {
+	return []byte(bucket + "\x00" + k)
+}
+
+// encryptValue seals the value stored at a key with the current key. Values
+// are returned as they are if there is no key.
+func encryptValue(bucket, k string, value []byte) ([]byte, error) {
+	if len(keyring) == 0 {
+		return value, nil
+	}
+	c := keyring[0]
+
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append(append(append([]byte{}, valueMagic...), c.id...), nonce...)
+
+	return c.aead.Seal(sealed, nonce, value, valueData(bucket, k)), nil
+}
+
+// sealedAs reports whether a stored value is sealed with the current key and
+// opens to value
+func sealedAs(bucket, k string, stored, value []byte) bool {
+	if len(keyring) == 0 || !encrypted(stored) {
+		return false
+	}
+
+	id := stored[len(valueMagic):]
+	if len(id) < keyIDSize || !bytes.Equal(id[:keyIDSize], keyring[0].id) {
+		return false
+	}
+
+	plain, err := decryptValue(bucket, k, stored)
+
+	return err == nil && bytes.Equal(plain, value)
+}
+
+// decryptValue opens the value stored at a key. Values that are not
+// encrypted are returned as they are.
+func decryptValue(bucket, k string, value []byte) ([]byte, error) {
+	if !encrypted(value) {
+		return value, nil
+	}
+
+	rest := value[len(valueMagic):]
+	if len(rest) < keyIDSize {
+		return nil, errors.New("encrypted value too short")
+	}
+	c := findKey(rest[:keyIDSize])
+	if c == nil {
+		return nil, keyError(rest[:keyIDSize])
+	}
+
+	rest = rest[keyIDSize:]
+	if len(rest) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted value too short")
+	}
+
+	return c.aead.Open(nil, rest[:c.aead.NonceSize()], rest[c.aead.NonceSize():], valueData(bucket, k))
+}
+
+// checkKey refuses databases whose values have been encrypted with a key
+// not at hand.
+func checkKey(db *bolt.DB) error {
+	meta, err := ReadMeta(db)
+	if err != nil || meta.EncryptionKey == "" {
+		return err
+	}
+
+	id, err := hex.DecodeString(meta.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	return keyError(id)
+}
+
+// encryptValues encrypts the word lists, statistics and history of a database
+// with the current key, be they in cleartext or encrypted with an older key.
+// Databases already encrypted with the current key, or without keys set,
+// are left alone. It returns the number of values encrypted.
+func encryptValues(db *bolt.DB) (values int, err error) {
+	if len(keyring) == 0 {
+		return values, nil
+	}
+	id := hex.EncodeToString(keyring[0].id)
+
+	var previous string
+
+	err = NewBoltStore(db).Update(func(tx Tx) error {
+		values = 0
+		previous = string(tx.Get("Meta", "EncryptionKey"))
+		if previous == id {
+			return nil
+		}
+
+		for _, path := range append(modelPaths(tx), "History") {
+			// copy the values, as they cannot be written while iterating
+			old := make(map[string][]byte)
+			err := tx.ForEach(path, func(k string, v []byte) error {
+				if encrypted(v) {
+					return fmt.Errorf("cannot decrypt %s in %s", k, path)
+				}
+				old[k] = append([]byte{}, v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for k, v := range old {
+				err = tx.Put(path, k, v)
+				if err != nil {
+					return err
+				}
+			}
+			values += len(old)
+		}
+
+		return tx.Put("Meta", "EncryptionKey", []byte(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if previous == id {
+		return 0, nil
+	}
+
+	msg := "Database encrypted"
+	if previous != "" {
+		msg = "Database key rotated"
+	}
+	log.WithFields(log.Fields{
+		"db":     db.Path(),
+		"values": values,
+	}).Info(msg)
+
+	return values, nil
+}
+
+// chunkNonce returns the nonce of the nth chunk of a backup file
+func chunkNonce(prefix []byte, n uint32) []byte {
+	nonce := make([]byte, len(prefix)+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], n)
+
+	return nonce
+}
+
+// chunkData returns the additional data authenticated with a chunk of a
+// backup file. It marks the last chunk, so a truncated file is noticed.
+func chunkData(header []byte, last bool) []byte {
+	data := append([]byte{}, header...)
+	if last {
+		return append(data, 1)
+	}
+
+	return append(data, 0)
+}
+
+// encryptFile writes the file at src to dst, encrypted with the current
+// key. The file is sealed in chunks, each prefixed with its length.
+func encryptFile(src, dst string) error {
+	if len(keyring) == 0 {
+		return ErrKeyRequired
+	}
+	c := keyring[0]
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, c.aead.NonceSize()-4)
+	_, err = rand.Read(prefix)
+	if err == nil {
+		err = sealChunks(bufio.NewWriter(out), in, c, prefix)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+
+	return err
+}
+
+// sealChunks writes the header of an encrypted file and the sealed chunks
+// of r to w.
+func sealChunks(w *bufio.Writer, r io.Reader, c key, prefix []byte) error {
+	header := append(append(append([]byte{}, fileMagic...), c.id...), prefix...)
+	_, err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := io.ReadFull(r, buf)
+	for i := uint32(0); ; i++ {
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+
+		// read ahead to tell the last chunk
+		var m int
+		last := err != nil
+		if !last {
+			m, err = io.ReadFull(r, next)
+			last = err == io.EOF
+		}
+
+		sealed := c.aead.Seal(nil, chunkNonce(prefix, i), buf[:n], chunkData(header, last))
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+		_, werr := w.Write(append(size[:], sealed...))
+		if werr != nil {
+			return werr
+		}
+
+		if last {
+			return w.Flush()
+		}
+		buf, next, n = next, buf, m
+	}
+}
+
+// encryptedFile reports whether the file at path has been written by
+// encryptFile.
+func encryptedFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(fileMagic))
+	_, err = io.ReadFull(f, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+
+	return bytes.Equal(magic, fileMagic), err
+}
+
+// decryptFile writes the file at src, written by encryptFile, to dst in
+// cleartext.
+func decryptFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	err = openChunks(w, bufio.NewReader(in))
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+
+	return err
+}
+
+// openChunks reads the header and the sealed chunks of an encrypted file
+// from r and writes them in cleartext to w.
+func openChunks(w io.Writer, r io.Reader) error {
+	header := make([]byte, len(fileMagic)+keyIDSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil || !bytes.HasPrefix(header, fileMagic) {
+		return errors.New("not an encrypted file")
+	}
+
+	id := header[len(fileMagic):]
+	c := findKey(id)
+	if c == nil {
+		return keyError(id)
+	}
+
+	prefix := make([]byte, c.aead.NonceSize()-4)
+	_, err = io.ReadFull(r, prefix)
+	if err != nil {
+		return err
+	}
+	header = append(header, prefix...)
+
+	for i := uint32(0); ; i++ {
+		var size [4]byte
+		_, err = io.ReadFull(r, size[:])
+		if err != nil {
+			return errors.New("encrypted file truncated")
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > chunkSize+uint32(c.aead.Overhead()) {
+			return errors.New("encrypted file corrupt")
+		}
+
+		sealed := make([]byte, n)
+		_, err = io.ReadFull(r, sealed)
+		if err != nil {
+			return errors.New("encrypted file truncated")
+		}
+
+		// try the chunk as the last one first, as most files are small
+		last := true
+		plain, err := c.aead.Open(nil, chunkNonce(prefix, i), sealed, chunkData(header, true))
+		if err != nil {
+			last = false
+			plain, err = c.aead.Open(nil, chunkNonce(prefix, i), sealed, chunkData(header, false))
+		}
+		if err != nil {
+			return errors.New("encrypted file corrupt")
+		}
+
+		_, err = w.Write(plain)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			var extra [1]byte
+			if m, _ := r.Read(extra[:]); m > 0 {
+				return errors.New("encrypted file corrupt")
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package sisyphus_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+
+	var oldKey, newKey []byte
+
+	// open (re)opens the database of the test Maildir
+	open := func() {
+		CloseDatabases(dbs)
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+	}
+
+	// london tells whether the learned junk word london is classified
+	london := func() {
+		answer, prob, err := Junk(dbs["test/Maildir"], []string{"london"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(prob).Should(Equal(1.0))
+		Ω(answer).Should(BeTrue())
+	}
+
+	// learn learns a junk and a good mail
+	learn := func() {
+		for _, m := range []*Mail{
+			{Key: "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa", Junk: true},
+			{Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119"},
+		} {
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	}
+
+	BeforeEach(func() {
+		keys, err := ParseKeys("# keys\n000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\nAAECAwQFBgcICQoLDA0ODw==")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(keys).Should(HaveLen(2))
+		oldKey, newKey = keys[0], keys[1]
+
+		err = SetEncryptionKeys([][]byte{oldKey})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+		learn()
+	})
+	AfterEach(func() {
+		SetEncryptionKeys(nil)
+		CloseDatabases(dbs)
+
+		files, err := filepath.Glob("test/Maildir/sisyphus.db*")
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, f := range files {
+			err = os.Remove(f)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	})
+
+	It("Rejects malformed keys", func() {
+		_, err := ParseKeys("0001")
+		Ω(err).Should(HaveOccurred())
+
+		_, err = ParseKeys("not a key")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Stores the word lists and statistics encrypted", func() {
+		err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+			v := tx.Bucket([]byte("Wordlists")).Bucket([]byte("Junk")).Get([]byte("london"))
+			Ω(string(v)).Should(HavePrefix("SYE1"))
+
+			v = tx.Bucket([]byte("Statistics")).Get([]byte("ProcessedJunk"))
+			Ω(string(v)).Should(HavePrefix("SYE1"))
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.EncryptionKey).ShouldNot(BeEmpty())
+
+		london()
+	})
+
+	It("Refuses to open a database without its key", func() {
+		SetEncryptionKeys(nil)
+		open()
+		Ω(err).Should(Equal(ErrKeyRequired))
+
+		SetEncryptionKeys([][]byte{newKey})
+		open()
+		Ω(err).Should(Equal(ErrUnknownKey))
+
+		SetEncryptionKeys([][]byte{oldKey})
+		open()
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Rotates the key when the database is opened", func() {
+		before, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+
+		SetEncryptionKeys([][]byte{newKey, oldKey})
+		open()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		after, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(after.EncryptionKey).ShouldNot(Equal(before.EncryptionKey))
+
+		SetEncryptionKeys([][]byte{newKey})
+		open()
+		Ω(err).ShouldNot(HaveOccurred())
+		london()
+	})
+
+	It("Encrypts databases holding cleartext when opened with a key", func() {
+		SetEncryptionKeys(nil)
+		CloseDatabases(dbs)
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		open()
+		Ω(err).ShouldNot(HaveOccurred())
+		learn()
+
+		var plain []byte
+		err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+			plain = append(plain, tx.Bucket([]byte("Wordlists")).Bucket([]byte("Junk")).Get([]byte("london"))...)
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		SetEncryptionKeys([][]byte{oldKey})
+		open()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.EncryptionKey).ShouldNot(BeEmpty())
+		london()
+
+		// the cleartext does not remain in free pages of the file
+		raw, err := ioutil.ReadFile("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(bytes.Contains(raw, plain)).Should(BeFalse())
+	})
+
+	It("Does not encrypt unchanged values again", func() {
+		// sealed reads the encrypted value of the learned junk word london
+		sealed := func() (v []byte) {
+			err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+				v = append(v, tx.Bucket([]byte("Wordlists")).Bucket([]byte("Junk")).Get([]byte("london"))...)
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			return v
+		}
+
+		before := sealed()
+		learn()
+		Ω(sealed()).Should(Equal(before))
+	})
+
+	Context("Backups", func() {
+		var path string
+
+		BeforeEach(func() {
+			path, err = Maildir("test/Maildir").Backup(dbs["test/Maildir"], 3)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Writes encrypted backups", func() {
+			raw, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bytes.Contains(raw, []byte("Wordlists"))).Should(BeFalse())
+
+			err = VerifyBackup(path)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			SetEncryptionKeys(nil)
+			err = VerifyBackup(path)
+			Ω(err).Should(Equal(ErrKeyRequired))
+		})
+
+		It("Detects tampered backups", func() {
+			raw, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			raw[len(raw)/2] ^= 1
+			err = ioutil.WriteFile(path, raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = VerifyBackup(path)
+			Ω(err).Should(HaveOccurred())
+
+			err = ioutil.WriteFile(path, raw[:len(raw)-100], 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = VerifyBackup(path)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("Restores and loads encrypted backups with old keys", func() {
+			SetEncryptionKeys([][]byte{newKey, oldKey})
+			CloseDatabases(dbs)
+
+			_, err = Maildir("test/Maildir").Restore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			dbs, err = LoadBackupDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			london()
+
+			open()
+			Ω(err).ShouldNot(HaveOccurred())
+			london()
+		})
+	})
+})
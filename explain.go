@@ -0,0 +1,105 @@
+package sisyphus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/carlostrub/maildir"
+	bolt "go.etcd.io/bbolt"
+)
+
+// findMail returns the mail with the given key, looking in new and in all
+// folders learned from.
+func (d Maildir) findMail(key string) (*Mail, error) {
+	m := &Mail{Key: key, New: true}
+	if _, err := maildir.Dir(m.folder(d)).Filename(key); err == nil {
+		return m, nil
+	}
+
+	folders, err := d.learnFolders()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range folders {
+		m = &Mail{Key: key, Folder: f.name, Junk: f.junk}
+		if _, err := maildir.Dir(m.folder(d)).Filename(key); err == nil {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no mail %s found", key)
+}
+
+// Explain tells how the words of a mail of the Maildir weigh in its
+// classification: for every word learned before, the number of good and junk
+// mails it was learned from and the probability of a mail containing it
+// being junk, most junk first. The words are taken from the mail, so they are
+// told even if the database only holds their hashes. A shared database is
+// not consulted.
+func (d Maildir) Explain(db *bolt.DB, key string) (tokens []Token, err error) {
+
+	m, err := d.findMail(key)
+	if err != nil {
+		return tokens, err
+	}
+
+	err = m.Load(d)
+	if err != nil {
+		return tokens, err
+	}
+	defer m.Unload(d)
+
+	t, err := storeTokenizer(NewBoltStore(db))
+	if err != nil {
+		return tokens, err
+	}
+
+	list, err := m.cleanWordlist(t)
+	if err != nil {
+		return tokens, err
+	}
+
+	sources, err := m.sources(NewBoltStore(db))
+	if err != nil {
+		return tokens, err
+	}
+
+	seen := make(map[string]bool)
+	for _, word := range list {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+
+		gN, jN, err := classificationLikelihoodWordcounts(sources, word)
+		if err != nil {
+			return tokens, err
+		}
+		if gN+jN == 0 {
+			continue
+		}
+
+		g, err := classificationWord(sources, word)
+		if err != nil {
+			return tokens, err
+		}
+
+		tokens = append(tokens, Token{
+			Word:  word,
+			Good:  uint64(math.Round(gN)),
+			Junk:  uint64(math.Round(jN)),
+			Score: 1 - g,
+		})
+	}
+
+	// most junk first, more evidence wins a tie
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Score != tokens[j].Score {
+			return tokens[i].Score > tokens[j].Score
+		}
+		return tokens[i].Good+tokens[i].Junk > tokens[j].Good+tokens[j].Junk
+	})
+
+	return tokens, nil
+}
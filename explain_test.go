@@ -0,0 +1,71 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Explain", func() {
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+			Junk: true,
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	// london tells whether the word london was explained as pure junk
+	london := func(tokens []Token) {
+		Ω(tokens).ShouldNot(BeEmpty())
+		Ω(tokens).Should(ContainElement(Token{Word: "london", Junk: 1, Score: 1}))
+		Ω(tokens[0].Score).Should(BeNumerically(">=", tokens[len(tokens)-1].Score))
+	}
+
+	It("Tells the words of a mail with their weight", func() {
+		tokens, err := Maildir("test/Maildir").Explain(dbs["test/Maildir"], "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161")
+		Ω(err).ShouldNot(HaveOccurred())
+		london(tokens)
+	})
+
+	It("Tells the words of a mail although they are hashed", func() {
+		err = SetTokenSecret([]byte("a secret of thirty-two bytes...."))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer SetTokenSecret(nil)
+
+		err = HashTokens(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+
+		tokens, err := Maildir("test/Maildir").Explain(dbs["test/Maildir"], "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161")
+		Ω(err).ShouldNot(HaveOccurred())
+		london(tokens)
+	})
+
+	It("Fails for unknown mails", func() {
+		_, err := Maildir("test/Maildir").Explain(dbs["test/Maildir"], "1500000000.M1P1.unknown")
+		Ω(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,182 @@
+package sisyphus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ExportVersion is the version of the export format written by Export
+const ExportVersion = 1
+
+// exportFormat identifies a stream as a sisyphus export
+const exportFormat = "sisyphus"
+
+// ExportHeader is the first line of an export and describes the model that
+// follows. Exports without a counter have been written before the counting
+// model was selectable and hold HyperLogLog sketches. Exports without
+// features or language learned single words as they are.
+type ExportHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Schema    int       `json:"schema"`
+	Tokenizer int       `json:"tokenizer"`
+	TokenHash string    `json:"token_hash,omitempty"`
+	Counter   string    `json:"counter,omitempty"`
+	Features  string    `json:"features,omitempty"`
+	Language  string    `json:"language,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// ExportRecord holds one key of the model. The value is the stored count,
+// e.g. a marshalled HyperLogLog sketch, base64 encoded in JSON.
+type ExportRecord struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Value  []byte `json:"value"`
+}
+
+// Export writes the learned model as JSON lines: a header followed by one
+// record per key of the Statistics, Wordlists and Learned buckets, all-time
+// and per period.
+func Export(db *bolt.DB, w io.Writer) error {
+
+	enc := json.NewEncoder(w)
+
+	return db.View(func(tx *bolt.Tx) error {
+		meta, err := readMeta(tx)
+		if err != nil {
+			return err
+		}
+
+		err = enc.Encode(ExportHeader{
+			Format:    exportFormat,
+			Version:   ExportVersion,
+			Schema:    meta.Schema,
+			Tokenizer: meta.Tokenizer,
+			TokenHash: meta.TokenHash,
+			Counter:   meta.Counter,
+			Features:  meta.Features,
+			Language:  meta.Language,
+			Created:   meta.Created,
+		})
+		if err != nil {
+			return err
+		}
+
+		t := boltTx{tx: tx}
+		for _, path := range append(modelPaths(t), learnedPaths(t)...) {
+			err = t.ForEach(path, func(k string, v []byte) error {
+				return enc.Encode(ExportRecord{
+					Bucket: path,
+					Key:    k,
+					Value:  v,
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Import replaces the learned model of the database by a model written by
+// Export: the Statistics, Wordlists and Learned buckets, all-time and per
+// period, are cleared before the records are read. The import happens in a
+// single transaction, so either the whole model is replaced or nothing.
+func Import(db *bolt.DB, r io.Reader) error {
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var header ExportHeader
+	err := dec.Decode(&header)
+	if err != nil {
+		return err
+	}
+	if header.Format != exportFormat {
+		return errors.New("not a sisyphus export")
+	}
+	if header.Version > ExportVersion {
+		return fmt.Errorf("unsupported export version %d", header.Version)
+	}
+	if header.Tokenizer != TokenizerVersion {
+		return errors.New("export was learned with a different tokenizer")
+	}
+
+	meta, err := ReadMeta(db)
+	if err != nil {
+		return err
+	}
+	if header.TokenHash != meta.TokenHash {
+		return errors.New("export and database hash their words differently")
+	}
+	if header.Counter == "" {
+		header.Counter = legacyCounting.String()
+	}
+	if header.Counter != meta.Counter {
+		return errors.New("export and database count their words differently")
+	}
+	if header.Features == "" {
+		header.Features = FeatureWords
+	}
+	if header.Features != meta.Features {
+		return errors.New("export and database learn different features")
+	}
+	if header.Language == "" {
+		header.Language = LanguageNone
+	}
+	if header.Language != meta.Language {
+		return errors.New("export and database reduce words in different languages")
+	}
+
+	return db.Update(func(btx *bolt.Tx) error {
+		for _, name := range []string{"Statistics", "Wordlists", "Learned", "Periods"} {
+			if btx.Bucket([]byte(name)) == nil {
+				continue
+			}
+			err := btx.DeleteBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		for _, path := range modelBuckets {
+			_, err := createBucketPath(btx, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		// words pruned from the replaced model say nothing about this one
+		err := btx.Bucket([]byte("Meta")).Delete([]byte("PrunedTokens"))
+		if err != nil {
+			return err
+		}
+
+		tx := boltTx{tx: btx}
+		for {
+			var rec ExportRecord
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if !isModelPath(rec.Bucket) && !isLearnedPath(rec.Bucket) {
+				return fmt.Errorf("unknown bucket %q", rec.Bucket)
+			}
+			err = tx.Put(rec.Bucket, rec.Key, rec.Value)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
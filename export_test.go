@@ -0,0 +1,116 @@
+package sisyphus_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Export", func() {
+
+	Context("Export and import a model", func() {
+		BeforeEach(func() {
+			err = LoadMaildirs([]Maildir{"test/Maildir2"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:  "1488226337.M327822P8269.mail.carlostrub.ch,S=3620,W=3730",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = os.RemoveAll("test/Maildir2")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Writes a header followed by one record per key", func() {
+			var buf bytes.Buffer
+			err = Export(dbs["test/Maildir"], &buf)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			scanner := bufio.NewScanner(&buf)
+			Ω(scanner.Scan()).Should(BeTrue())
+
+			var header ExportHeader
+			err = json.Unmarshal(scanner.Bytes(), &header)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(header.Version).Should(Equal(ExportVersion))
+			Ω(header.Schema).Should(Equal(SchemaVersion))
+			Ω(header.Features).Should(Equal(FeatureWords))
+			Ω(header.Language).Should(Equal(LanguageNone))
+
+			// 27 words, a counter and the mail learned
+			var n int
+			for scanner.Scan() {
+				n++
+			}
+			Ω(n).Should(Equal(29))
+		})
+
+		It("Imports an exported model into another database", func() {
+			var buf bytes.Buffer
+			err = Export(dbs["test/Maildir"], &buf)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			// the model learned before is replaced
+			m = &Mail{
+				Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			}
+			err = m.Learn(dbs["test/Maildir2"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = Import(dbs["test/Maildir2"], &buf)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			var gN, jN, sN int
+			err = dbs["test/Maildir2"].View(func(tx *bolt.Tx) error {
+				gN = tx.Bucket([]byte("Wordlists")).Bucket([]byte("Good")).Stats().KeyN
+				jN = tx.Bucket([]byte("Wordlists")).Bucket([]byte("Junk")).Stats().KeyN
+				sN = tx.Bucket([]byte("Statistics")).Stats().KeyN
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(gN).Should(Equal(0))
+			Ω(jN).Should(Equal(27))
+			Ω(sN).Should(Equal(1))
+		})
+
+		It("Rejects a model learned in another language", func() {
+			var buf bytes.Buffer
+			err = Export(dbs["test/Maildir"], &buf)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			CloseDatabases(dbs)
+			err = SetLanguage("test/Maildir2", LanguageGerman)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer SetLanguage("test/Maildir2", "")
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = Import(dbs["test/Maildir2"], &buf)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("Rejects input that is not an export", func() {
+			err = Import(dbs["test/Maildir2"], strings.NewReader(`{"format":"other"}`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
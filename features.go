@@ -0,0 +1,168 @@
+package sisyphus
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// FeatureWords learns the single words of a mail only.
+	FeatureWords = "words"
+
+	// FeatureBigrams learns pairs of adjacent words in addition to the
+	// single words, e.g. "click here". They are stored with the prefix b:,
+	// e.g. "b:click here".
+	FeatureBigrams = "bigrams"
+
+	// FeatureOSB learns orthogonal sparse bigrams in addition to the single
+	// words: every pair of words at most osbWindow-1 words apart, keyed by
+	// their distance. They are stored with the prefix o and the distance,
+	// e.g. "o1:wire transfer" or "o3:dear friend" for "dear old friend".
+	FeatureOSB = "osb"
+)
+
+// osbWindow is the number of consecutive words orthogonal sparse bigrams are
+// formed within
+const osbWindow = 5
+
+// maildirSetting is a setting of the databases of Maildirs: the value of
+// all Maildirs not given their own, and the values given, by the path of
+// the database of their Maildir
+type maildirSetting struct {
+	all  string
+	dirs map[string]string
+}
+
+// settingPath returns the path of the database of a Maildir its settings
+// are kept by. It does not look at the disk, so Maildirs can be configured
+// before they are created.
+func settingPath(d Maildir) string {
+	return filepath.Clean(filepath.Join(string(d), "sisyphus.db"))
+}
+
+// set sets the value of a Maildir, or that of all Maildirs not given their
+// own if d is empty. An empty value makes the Maildir take that of all
+// others again.
+func (s *maildirSetting) set(d Maildir, v string) {
+	path := settingPath(d)
+	switch {
+	case d == "":
+		s.all = v
+	case v == "":
+		delete(s.dirs, path)
+	default:
+		s.dirs[path] = v
+	}
+}
+
+// of returns the value of the database at path
+func (s *maildirSetting) of(path string) string {
+	if v, ok := s.dirs[filepath.Clean(path)]; ok {
+		return v
+	}
+
+	return s.all
+}
+
+// setMeta records a setting of a database in its metadata, where a missing
+// value counts as fallback
+func setMeta(db *bolt.DB, key, value, fallback string) error {
+	return NewBoltStore(db).Update(func(tx Tx) error {
+		old := metaString(tx, key, fallback)
+		if old == value {
+			return nil
+		}
+
+		log.WithFields(log.Fields{
+			"db":   db.Path(),
+			"from": old,
+			"to":   value,
+		}).Info(key + " changed")
+
+		return tx.Put("Meta", key, []byte(value))
+	})
+}
+
+// metaString returns a setting recorded in the metadata of a store, or
+// fallback if there is none
+func metaString(tx Tx, key, fallback string) string {
+	raw := tx.Get("Meta", key)
+	if len(raw) == 0 {
+		return fallback
+	}
+
+	return string(raw)
+}
+
+// features are the features learned by the databases of Maildirs
+var features = maildirSetting{all: FeatureWords, dirs: make(map[string]string)}
+
+// ParseFeatures checks the name of features to learn: words, bigrams or osb
+func ParseFeatures(s string) (string, error) {
+	switch s {
+	case FeatureWords, FeatureBigrams, FeatureOSB:
+		return s, nil
+	}
+
+	return s, fmt.Errorf("unknown features %q", s)
+}
+
+// SetFeatures sets the features learned by the database of a Maildir from
+// the time it is next opened, or those of all Maildirs not given their own
+// if d is empty. Empty features make the Maildir learn those of all others
+// again. Mails learned before are not learned anew, so features added only
+// count once the mails are learned again.
+func SetFeatures(d Maildir, f string) error {
+	if d == "" || f != "" {
+		_, err := ParseFeatures(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	features.set(d, f)
+
+	return nil
+}
+
+// Features returns the features learned by the database of the Maildir
+func (d Maildir) Features() string {
+	return features.of(settingPath(d))
+}
+
+// phrases returns the pairs of words formed from the words of a mail in the
+// order they appear, as selected by features. Each pair is returned once.
+func phrases(words []string, features string) (l []string) {
+	var window int
+	switch features {
+	case FeatureBigrams:
+		window = 2
+	case FeatureOSB:
+		window = osbWindow
+	default:
+		return l
+	}
+
+	seen := make(map[string]bool)
+	for i, first := range words {
+		for d := 1; d < window && i+d < len(words); d++ {
+			p := "o" + strconv.Itoa(d) + ":" + first + " " + words[i+d]
+			if features == FeatureBigrams {
+				p = "b:" + first + " " + words[i+d]
+			}
+
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			l = append(l, p)
+		}
+	}
+
+	return l
+}
@@ -0,0 +1,168 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Features", func() {
+
+	const junkKey = "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa"
+
+	// use opens the database of the test Maildir and learns a junk and a
+	// good mail
+	use := func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		for _, m := range []*Mail{
+			{Key: junkKey, Junk: true},
+			{Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119"},
+		} {
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	}
+
+	// prefixes counts the words of the junk mail learned by their prefix
+	prefixes := func() map[string]int {
+		tokens, err := Maildir("test/Maildir").Explain(dbs["test/Maildir"], junkKey)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		n := make(map[string]int)
+		for _, t := range tokens {
+			prefix := ""
+			if i := strings.Index(t.Word, ":"); i >= 0 {
+				prefix = t.Word[:i]
+			}
+			n[prefix]++
+		}
+
+		return n
+	}
+
+	AfterEach(func() {
+		SetFeatures("", FeatureWords)
+		SetFeatures("test/Maildir", "")
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		if !os.IsNotExist(err) {
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	})
+
+	It("Parses features", func() {
+		use()
+
+		for _, s := range []string{"words", "bigrams", "osb"} {
+			f, err := ParseFeatures(s)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(f).Should(Equal(s))
+		}
+
+		_, err = ParseFeatures("trigrams")
+		Ω(err).Should(HaveOccurred())
+
+		err = SetFeatures("test/Maildir", "trigrams")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Learns single words by default", func() {
+		use()
+
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Features).Should(Equal(FeatureWords))
+
+		n := prefixes()
+		Ω(n[""]).Should(BeNumerically(">", 0))
+		Ω(n).Should(HaveLen(1))
+	})
+
+	It("Learns bigrams of adjacent words", func() {
+		err = SetFeatures("", FeatureBigrams)
+		Ω(err).ShouldNot(HaveOccurred())
+		use()
+
+		n := prefixes()
+		Ω(n[""]).Should(BeNumerically(">", 0))
+		Ω(n["b"]).Should(BeNumerically(">", 0))
+		Ω(n).Should(HaveLen(2))
+
+		answer, prob, err := Junk(dbs["test/Maildir"], []string{"london"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(prob).Should(Equal(1.0))
+		Ω(answer).Should(BeTrue())
+	})
+
+	It("Learns orthogonal sparse bigrams of the Maildir", func() {
+		err = SetFeatures("", FeatureBigrams)
+		Ω(err).ShouldNot(HaveOccurred())
+		err = SetFeatures("test/Maildir", FeatureOSB)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(Maildir("test/Maildir").Features()).Should(Equal(FeatureOSB))
+		use()
+
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Features).Should(Equal(FeatureOSB))
+
+		n := prefixes()
+		for _, prefix := range []string{"", "o1", "o2", "o3", "o4"} {
+			Ω(n[prefix]).Should(BeNumerically(">", 0))
+		}
+		Ω(n).Should(HaveLen(5))
+	})
+
+	It("Keeps the features of a Maildir configured before it exists", func() {
+		dir, err := ioutil.TempDir("", "sisyphus")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		d := Maildir(filepath.Join(dir, "Maildir"))
+		err = SetFeatures(d, FeatureOSB)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer SetFeatures(d, "")
+		Ω(d.Features()).Should(Equal(FeatureOSB))
+
+		err = os.Mkdir(string(d), 0700)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(d.Features()).Should(Equal(FeatureOSB))
+
+		db, err := OpenDatabase(string(d))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer db.Close()
+
+		meta, err := ReadMeta(db)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Features).Should(Equal(FeatureOSB))
+	})
+
+	It("Switches the features when the database is opened", func() {
+		err = SetFeatures("test/Maildir", FeatureOSB)
+		Ω(err).ShouldNot(HaveOccurred())
+		use()
+		CloseDatabases(dbs)
+
+		err = SetFeatures("test/Maildir", FeatureWords)
+		Ω(err).ShouldNot(HaveOccurred())
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Features).Should(Equal(FeatureWords))
+		Ω(prefixes()).Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,95 @@
+package sisyphus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlagPolicy tells how good mails carrying a Maildir flag are learned. Weight
+// counts such a mail as several mails; Skip leaves it out of learning.
+type FlagPolicy struct {
+	Weight int
+	Skip   bool
+}
+
+// FlagPolicies are the policies per Maildir flag
+type FlagPolicies map[rune]FlagPolicy
+
+// DefaultFlagPolicies counts mails the user replied to (R), forwarded (P) or
+// flagged (F) twice, and skips mails marked as trashed (T).
+var DefaultFlagPolicies = FlagPolicies{
+	'R': {Weight: 2},
+	'P': {Weight: 2},
+	'F': {Weight: 2},
+	'T': {Skip: true},
+}
+
+// ParseFlagPolicies reads policies from a comma-separated list of a flag and
+// either a weight or "skip", e.g. R=2,P=2,F=3,T=skip. An empty string means
+// no policies.
+func ParseFlagPolicies(s string) (p FlagPolicies, err error) {
+	p = make(FlagPolicies)
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		f := strings.SplitN(v, "=", 2)
+		if len(f) != 2 || len(f[0]) != 1 {
+			return p, fmt.Errorf("invalid flag policy %q", v)
+		}
+		flag := rune(f[0][0])
+
+		if f[1] == "skip" {
+			p[flag] = FlagPolicy{Skip: true}
+			continue
+		}
+
+		w, err := strconv.Atoi(f[1])
+		if err != nil || w < 1 {
+			return p, fmt.Errorf("invalid weight in flag policy %q", v)
+		}
+		p[flag] = FlagPolicy{Weight: w}
+	}
+
+	return p, nil
+}
+
+// Apply sets the weight of a good mail according to its flags, using the
+// highest weight of all its flags. It returns false if the mail should not be
+// learned at all. Junk mails are learned as they are.
+func (p FlagPolicies) Apply(m *Mail) (learn bool) {
+	m.Weight = 1
+	if m.Junk {
+		return true
+	}
+
+	for _, f := range m.Flags {
+		policy, ok := p[f]
+		switch {
+		case !ok:
+			continue
+		case policy.Skip:
+			return false
+		case policy.Weight > m.Weight:
+			m.Weight = policy.Weight
+		}
+	}
+
+	return true
+}
+
+// learnKeys returns the keys under which the mail is learned. A mail with a
+// weight above one is learned under additional keys derived from its own, so
+// the counters take it for several mails.
+func (m *Mail) learnKeys() []string {
+	keys := []string{m.Key}
+	for i := 2; i <= m.Weight; i++ {
+		keys = append(keys, m.Key+"/"+strconv.Itoa(i))
+	}
+
+	return keys
+}
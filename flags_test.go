@@ -0,0 +1,74 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Flags", func() {
+
+	Context("Parse flag policies", func() {
+		It("Reads weights and skips", func() {
+			p, err := ParseFlagPolicies("R=3, T=skip")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p).Should(Equal(FlagPolicies{
+				'R': {Weight: 3},
+				'T': {Skip: true},
+			}))
+		})
+
+		It("Fails on invalid policies", func() {
+			_, err := ParseFlagPolicies("R")
+			Ω(err).Should(HaveOccurred())
+			_, err = ParseFlagPolicies("RS=2")
+			Ω(err).Should(HaveOccurred())
+			_, err = ParseFlagPolicies("R=0")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Apply flag policies", func() {
+		It("Uses the highest weight of all flags", func() {
+			m := &Mail{Flags: "FRS"}
+			Ω(FlagPolicies{'F': {Weight: 3}, 'R': {Weight: 2}}.Apply(m)).Should(BeTrue())
+			Ω(m.Weight).Should(Equal(3))
+		})
+
+		It("Skips trashed mails in the inbox only", func() {
+			Ω(DefaultFlagPolicies.Apply(&Mail{Flags: "ST"})).Should(BeFalse())
+			Ω(DefaultFlagPolicies.Apply(&Mail{Flags: "ST", Junk: true})).Should(BeTrue())
+		})
+	})
+
+	Context("Learn a weighted mail", func() {
+		BeforeEach(func() {
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Counts the mail as several mails", func() {
+			m = &Mail{
+				Key:   "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+				Flags: "RS",
+			}
+			Ω(DefaultFlagPolicies.Apply(m)).Should(BeTrue())
+
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.GoodMails).Should(Equal(uint64(2)))
+		})
+	})
+})
@@ -0,0 +1,319 @@
+package sisyphus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Problem is a corrupt part of a database found by Fsck. Problems without a
+// bucket concern the database file itself.
+type Problem struct {
+	Bucket   string `json:"bucket,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Err      string `json:"error"`
+	Repaired bool   `json:"repaired"`
+}
+
+func (p Problem) String() string {
+	s := p.Err
+	if p.Bucket != "" {
+		s = fmt.Sprintf("%s %q: %s", p.Bucket, p.Key, p.Err)
+	}
+	if p.Repaired {
+		s += " (repaired)"
+	}
+
+	return s
+}
+
+// FsckRequest selects what Fsck repairs. Repair deletes corrupt entries and
+// creates missing buckets. RebuildStatistics counts the mails of the Maildir
+// anew, learning them with the flag policies given, or the default ones if
+// none are.
+type FsckRequest struct {
+	Repair            bool         `json:"repair"`
+	RebuildStatistics bool         `json:"rebuild_statistics"`
+	Policies          FlagPolicies `json:"policies"`
+}
+
+// FsckReport lists the problems Fsck found and, if statistics were rebuilt,
+// the number of good and junk mails counted.
+type FsckReport struct {
+	Problems  []Problem `json:"problems"`
+	GoodMails int       `json:"good_mails"`
+	JunkMails int       `json:"junk_mails"`
+}
+
+// errNotSketch is the problem of a value that is not a HyperLogLog sketch
+var errNotSketch = errors.New("not a HyperLogLog sketch")
+
+// checkSketch validates a value of the learned model
+func checkSketch(c counter, path, key string, value []byte) error {
+	if strings.HasSuffix(path, "Statistics") && key != "ProcessedGood" && key != "ProcessedJunk" {
+		return errors.New("unknown statistics key")
+	}
+
+	return c.check(key, value)
+}
+
+// checkLearned validates an entry of the keys of the mails learned
+func checkLearned(_ string, value []byte) error {
+	if string(value) != "Good" && string(value) != "Junk" {
+		return errors.New("not a class")
+	}
+
+	return nil
+}
+
+// checkTime validates a time stamp as used for keys of the history
+func checkTime(value []byte) error {
+	if len(value) != 8 {
+		return errors.New("not a time stamp")
+	}
+
+	return nil
+}
+
+// checks validates the entries of the buckets besides the learned model
+var checks = map[string]func(key string, value []byte) error{
+	"Scores": func(key string, value []byte) error {
+		if len(value) != 8 {
+			return errors.New("not a score")
+		}
+		return checkTime([]byte(key))
+	},
+	"History": func(key string, value []byte) error {
+		var e HistoryEntry
+		err := json.Unmarshal(value, &e)
+		if err != nil {
+			return errors.New("not a history entry")
+		}
+		return checkTime([]byte(key))
+	},
+	"Rescued": func(_ string, value []byte) error {
+		return checkTime(value)
+	},
+	"Allowlist": func(_ string, value []byte) error {
+		_, err := time.Parse(time.RFC3339, string(value))
+		return err
+	},
+}
+
+// checkBuckets validates every entry of the database and, if repair is set,
+// deletes corrupt entries and creates missing buckets.
+func checkBuckets(tx *bolt.Tx, repair bool) (problems []Problem, err error) {
+	t := boltTx{tx: tx}
+
+	required := append(append([]string{}, modelBuckets...), "Scores", "History", "Rescued", "Allowlist")
+	for _, path := range required {
+		if bucketPath(tx, path) != nil {
+			continue
+		}
+		p := Problem{Bucket: path, Err: "bucket missing"}
+		if repair {
+			_, err = createBucketPath(tx, path)
+			if err != nil {
+				return problems, err
+			}
+			p.Repaired = true
+		}
+		problems = append(problems, p)
+	}
+
+	check := func(path string, fn func(key string, value []byte) error) error {
+		var corrupt []Problem
+		err := t.ForEach(path, func(k string, v []byte) error {
+			if err := fn(k, v); err != nil {
+				corrupt = append(corrupt, Problem{Bucket: path, Key: k, Err: err.Error()})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys cannot be deleted while iterating
+		for _, p := range corrupt {
+			if repair {
+				err = t.Delete(p.Bucket, p.Key)
+				if err != nil {
+					return err
+				}
+				p.Repaired = true
+			}
+			problems = append(problems, p)
+		}
+
+		return nil
+	}
+
+	for _, path := range modelPaths(t) {
+		path := path
+		c, err := counterOf(t, path)
+		if err != nil {
+			return problems, err
+		}
+		err = check(path, func(k string, v []byte) error {
+			return checkSketch(c, path, k, v)
+		})
+		if err != nil {
+			return problems, err
+		}
+	}
+	for _, path := range learnedPaths(t) {
+		err = check(path, checkLearned)
+		if err != nil {
+			return problems, err
+		}
+	}
+	for _, path := range []string{"Scores", "History", "Rescued", "Allowlist"} {
+		err = check(path, checks[path])
+		if err != nil {
+			return problems, err
+		}
+	}
+
+	return problems, nil
+}
+
+// rebuildStatistics replaces the statistics, all-time and per period, by
+// counting the mails of the Maildir, or the keys of the mails learned if the
+// counting model keeps them.
+func (d Maildir) rebuildStatistics(tx Tx, policies FlagPolicies) (good, junk int, err error) {
+	c, err := countingOf(tx)
+	if err != nil {
+		return good, junk, err
+	}
+
+	learned := make(map[[2]string][]string)
+	if c.identities() {
+		for _, path := range learnedPaths(tx) {
+			prefix := strings.TrimSuffix(path, "Learned")
+			err = tx.ForEach(path, func(k string, v []byte) error {
+				if checkLearned(k, v) != nil {
+					return nil
+				}
+				id := [2]string{prefix + "Statistics", "Processed" + string(v)}
+				learned[id] = append(learned[id], k)
+				return nil
+			})
+			if err != nil {
+				return good, junk, err
+			}
+		}
+		good = len(learned[[2]string{"Statistics", "ProcessedGood"}])
+		junk = len(learned[[2]string{"Statistics", "ProcessedJunk"}])
+	} else {
+		mails, err := d.Index()
+		if err != nil {
+			return good, junk, err
+		}
+
+		// mails count in the periods they have been learned into
+		learnedPeriods := make(map[string]bool)
+		for _, p := range periods(tx) {
+			learnedPeriods[p] = true
+		}
+
+		for _, m := range mails {
+			if !policies.Apply(m) {
+				continue
+			}
+			if m.Junk {
+				junk++
+			} else {
+				good++
+			}
+
+			prefixes := []string{""}
+			if learnedPeriods[m.period()] {
+				prefixes = append(prefixes, periodPrefix(m.period()))
+			}
+			for _, prefix := range prefixes {
+				id := [2]string{prefix + "Statistics", "Processed" + m.class()}
+				learned[id] = append(learned[id], m.learnKeys()...)
+			}
+		}
+	}
+
+	prefixes := []string{""}
+	for _, p := range periods(tx) {
+		prefixes = append(prefixes, periodPrefix(p))
+	}
+	for _, prefix := range prefixes {
+		for _, key := range []string{"ProcessedGood", "ProcessedJunk"} {
+			err = tx.Delete(prefix+"Statistics", key)
+			if err != nil {
+				return good, junk, err
+			}
+		}
+	}
+
+	for id, keys := range learned {
+		err = addCount(tx, id[0], id[1], keys)
+		if err != nil {
+			return good, junk, err
+		}
+	}
+
+	return good, junk, nil
+}
+
+// Fsck checks the consistency of the database file and validates every
+// entry: the counts of the learned model, the statistics keys, the keys of
+// the mails learned, the scores, the history, rescued mails and allowed
+// senders. Corrupt counts make every classification of a mail containing
+// their word fail, so they are best deleted with Repair, at the price of
+// forgetting that word. Corrupt statistics are fixed with RebuildStatistics,
+// which counts only the mails that are still in the Maildir, unless the
+// counting model keeps the keys of the mails learned. A database file found
+// inconsistent is not touched; restore a backup instead.
+func (d Maildir) Fsck(db *bolt.DB, r FsckRequest) (report FsckReport, err error) {
+
+	err = db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			report.Problems = append(report.Problems, Problem{Err: err.Error()})
+		}
+		return nil
+	})
+	if err != nil || len(report.Problems) > 0 {
+		return report, err
+	}
+
+	update := db.View
+	if r.Repair || r.RebuildStatistics {
+		update = db.Update
+	}
+
+	err = update(func(tx *bolt.Tx) (err error) {
+		report.Problems, err = checkBuckets(tx, r.Repair)
+		if err != nil || !r.RebuildStatistics {
+			return err
+		}
+
+		policies := r.Policies
+		if policies == nil {
+			policies = DefaultFlagPolicies
+		}
+		report.GoodMails, report.JunkMails, err = d.rebuildStatistics(boltTx{tx: tx}, policies)
+
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	log.WithFields(log.Fields{
+		"dir":      string(d),
+		"problems": len(report.Problems),
+	}).Info("Database checked")
+
+	return report, nil
+}
@@ -0,0 +1,100 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fsck", func() {
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+			Junk: true,
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Finds nothing in a sound database", func() {
+		report, err := Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(report.Problems).Should(BeEmpty())
+	})
+
+	Context("Corrupt entries", func() {
+		BeforeEach(func() {
+			err = dbs["test/Maildir"].Update(func(tx *bolt.Tx) error {
+				err := tx.Bucket([]byte("Wordlists")).Bucket([]byte("Junk")).Put([]byte("london"), []byte("garbage"))
+				if err != nil {
+					return err
+				}
+				return tx.Bucket([]byte("Statistics")).Put([]byte("ProcessedJunk"), []byte("garbage"))
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Reports corrupt entries without touching them", func() {
+			_, _, err := Junk(dbs["test/Maildir"], []string{"london"})
+			Ω(err).Should(HaveOccurred())
+
+			_, err = Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).Should(HaveOccurred())
+
+			report, err := Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report.Problems).Should(ConsistOf(
+				Problem{Bucket: "Statistics", Key: "ProcessedJunk", Err: "not a HyperLogLog sketch"},
+				Problem{Bucket: "Wordlists/Junk", Key: "london", Err: "not a HyperLogLog sketch"},
+			))
+
+			report, err = Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report.Problems).Should(HaveLen(2))
+		})
+
+		It("Repairs corrupt entries and rebuilds the statistics", func() {
+			report, err := Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{
+				Repair:            true,
+				RebuildStatistics: true,
+				Policies:          FlagPolicies{},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report.Problems).Should(HaveLen(2))
+			for _, p := range report.Problems {
+				Ω(p.Repaired).Should(BeTrue())
+			}
+			Ω(report.GoodMails).Should(Equal(1))
+			Ω(report.JunkMails).Should(Equal(10))
+
+			report, err = Maildir("test/Maildir").Fsck(dbs["test/Maildir"], FsckRequest{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report.Problems).Should(BeEmpty())
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.GoodMails).Should(Equal(uint64(1)))
+			Ω(s.JunkMails).Should(Equal(uint64(10)))
+
+			_, _, err = Junk(dbs["test/Maildir"], []string{"london"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+})
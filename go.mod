@@ -1,20 +1,43 @@
 module github.com/carlostrub/sisyphus
 
-go 1.13
+go 1.17
 
 require (
-	github.com/boltdb/bolt v1.3.1
 	github.com/carlostrub/maildir v0.0.0-20180111195716-eed2685d6889
-	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/gonum/stat v0.0.0-20181125101827-41a0da705a5b
+	github.com/kennygrant/sanitize v1.2.4
+	github.com/onsi/ginkgo v1.16.5
+	github.com/onsi/gomega v1.10.4
+	github.com/prometheus/client_golang v1.12.2
+	github.com/retailnext/hllpp v1.0.0
+	github.com/sirupsen/logrus v1.6.0
+	github.com/urfave/cli v1.22.2
+	go.etcd.io/bbolt v1.3.6
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/cpuguy83/go-md2man/v2 v2.0.0-20190314233015-f79a8a8ca69d // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/gonum/blas v0.0.0-20181208220705-f22b278b28ac // indirect
 	github.com/gonum/floats v0.0.0-20181209220543-c233463c7e82 // indirect
 	github.com/gonum/internal v0.0.0-20181124074243-f884aa714029 // indirect
 	github.com/gonum/lapack v0.0.0-20181123203213-e4cdc5a0bff9 // indirect
 	github.com/gonum/matrix v0.0.0-20181209220409-c518dec07be9 // indirect
-	github.com/gonum/stat v0.0.0-20181125101827-41a0da705a5b
-	github.com/kennygrant/sanitize v1.2.4
-	github.com/retailnext/hllpp v1.0.0
-	github.com/sirupsen/logrus v1.4.2
-	github.com/urfave/cli v1.22.2 // indirect
-	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/nxadm/tail v1.4.8 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.32.1 // indirect
+	github.com/prometheus/procfs v0.7.3 // indirect
+	github.com/russross/blackfriday/v2 v2.0.1 // indirect
+	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
+	google.golang.org/protobuf v1.26.0 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
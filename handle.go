@@ -0,0 +1,99 @@
+package sisyphus
+
+import (
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Handle is an open database that can be replaced while it is in use, e.g.
+// by a compacted copy. The daemon shares one Handle per Maildir between
+// everything that uses its database.
+type Handle struct {
+	mu sync.RWMutex
+	db *bolt.DB
+}
+
+// NewHandle returns a Handle of an open database.
+func NewHandle(db *bolt.DB) *Handle {
+	return &Handle{db: db}
+}
+
+// Use calls fn with the database. The database is not replaced before fn
+// returns, so fn must not keep it.
+func (h *Handle) Use(fn func(db *bolt.DB) error) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return fn(h.db)
+}
+
+// Close closes the database.
+func (h *Handle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.db.Close()
+}
+
+// swap replaces the file of the database by the one at src and opens it
+// again. The caller must hold the write lock. If the replacement cannot be
+// put in place or opened, the old file is opened again and the error is
+// returned; only if that fails too, the Handle is left with the closed
+// database.
+func (h *Handle) swap(src string) error {
+	path := h.db.Path()
+	old := path + ".swap"
+
+	err := h.db.Close()
+	if err != nil {
+		return err
+	}
+
+	// Keep the old file until the replacement has been opened
+	os.Remove(old)
+	err = os.Link(path, old)
+	if err == nil {
+		err = os.Rename(src, path)
+	}
+	if err == nil {
+		var db *bolt.DB
+		db, err = OpenDatabase(path)
+		if err == nil {
+			h.db = db
+			os.Remove(old)
+			return nil
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"db":  path,
+		"src": src,
+		"err": err,
+	}).Error("Unable to replace database, reopening the old one")
+
+	if _, serr := os.Stat(old); serr == nil {
+		rerr := os.Rename(old, path)
+		if rerr != nil {
+			log.WithFields(log.Fields{
+				"db":  path,
+				"err": rerr,
+			}).Error("Unable to restore database")
+		}
+	}
+
+	db, oerr := OpenDatabase(path)
+	if oerr != nil {
+		log.WithFields(log.Fields{
+			"db":  path,
+			"err": oerr,
+		}).Error("Unable to reopen database")
+		return err
+	}
+	h.db = db
+
+	return err
+}
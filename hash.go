@@ -0,0 +1,185 @@
+package sisyphus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// minTokenSecretSize is the least number of bytes of a token secret
+const minTokenSecretSize = 16
+
+var (
+	// ErrTokenSecretRequired is returned when a database hashing its words
+	// is opened without any token secret.
+	ErrTokenSecretRequired = errors.New("database hashes its words, but no token secret is given")
+
+	// ErrUnknownTokenSecret is returned when a database hashing its words
+	// is opened with another token secret than it hashes with.
+	ErrUnknownTokenSecret = errors.New("database hashes its words with an unknown token secret")
+)
+
+// tokenSecret is the secret words are hashed with. It is empty if databases
+// opened from now on keep their words in cleartext.
+var tokenSecret []byte
+
+// SetTokenSecret sets the secret databases opened from now on hash their
+// words with, see HashTokens. The secret is kept outside the databases, so
+// whoever reads a database can neither tell its words nor test for them.
+// Databases hashing their words can only be opened with the secret they
+// hash with. Without a secret, words are stored in cleartext.
+func SetTokenSecret(secret []byte) error {
+	if len(secret) > 0 && len(secret) < minTokenSecretSize {
+		return fmt.Errorf("token secret has %d bytes, instead of at least %d", len(secret), minTokenSecretSize)
+	}
+
+	tokenSecret = secret
+
+	return nil
+}
+
+// tokenKey returns the key a word is stored under in a store: the word
+// itself, or its keyed hash if the store hashes its words.
+func tokenKey(tx Tx, word string) string {
+	if len(tx.Get("Meta", "TokenHash")) == 0 {
+		return word
+	}
+
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(word))
+
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// tokenHashID returns a fingerprint of a token secret, so databases hashing
+// their words alike can be recognized without revealing the secret. It is
+// empty for databases storing their words in cleartext.
+func tokenHashID(secret []byte) string {
+	if len(secret) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(secret)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkTokenSecret refuses databases whose words have been hashed with a
+// secret not at hand.
+func checkTokenSecret(db *bolt.DB) error {
+	var hash string
+	err := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte("Meta")); b != nil {
+			hash = string(b.Get([]byte("TokenHash")))
+		}
+		return nil
+	})
+	if err != nil || hash == "" || hash == tokenHashID(tokenSecret) {
+		return err
+	}
+	if len(tokenSecret) == 0 {
+		return ErrTokenSecretRequired
+	}
+
+	return ErrUnknownTokenSecret
+}
+
+// HashTokens replaces the words of a database by their keyed hashes, using
+// the secret set by SetTokenSecret. Only a fingerprint of the secret is kept
+// in the database, so whoever reads it no longer sees the vocabulary of the
+// mails learned, nor can test whether given words were learned. Words can
+// only be told again from the mails they were learned from, e.g. by Explain.
+// Databases that already hash their words are left alone. This cannot be
+// undone. Count-min sketches cannot tell their words, so they can only be
+// switched to hashing before anything has been learned. The words remain in
+// free pages of the file until it is compacted, which OpenDatabase does
+// right away, see CompactDatabase.
+func HashTokens(db *bolt.DB) error {
+	_, err := hashTokens(db)
+
+	return err
+}
+
+// hashTokens works like HashTokens and returns the number of words hashed
+func hashTokens(db *bolt.DB) (words int, err error) {
+
+	if len(tokenSecret) == 0 {
+		return words, ErrTokenSecretRequired
+	}
+
+	err = NewBoltStore(db).Update(func(tx Tx) error {
+		words = 0
+		if len(tx.Get("Meta", "TokenHash")) > 0 {
+			return nil
+		}
+
+		c, err := countingOf(tx)
+		if err != nil {
+			return err
+		}
+		if c.Model == CountMin {
+			for _, path := range modelPaths(tx) {
+				if strings.Contains(path, "Wordlists/") && tx.Len(path) > 0 {
+					return errors.New("words of a count-min sketch cannot be hashed, please rebuild the database")
+				}
+			}
+		}
+
+		err = tx.Put("Meta", "TokenHash", []byte(tokenHashID(tokenSecret)))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range modelPaths(tx) {
+			if !strings.Contains(path, "Wordlists/") {
+				continue
+			}
+
+			// copy the words, as they are all deleted before they are
+			// written again
+			old := make(map[string][]byte)
+			err = tx.ForEach(path, func(k string, v []byte) error {
+				old[k] = append([]byte{}, v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for k := range old {
+				err = tx.Delete(path, k)
+				if err != nil {
+					return err
+				}
+			}
+			for k, v := range old {
+				err = tx.Put(path, tokenKey(tx, k), v)
+				if err != nil {
+					return err
+				}
+			}
+			words += len(old)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if words > 0 {
+		log.WithFields(log.Fields{
+			"db":    db.Path(),
+			"words": words,
+		}).Info("Words replaced by hashes")
+	}
+
+	return words, nil
+}
@@ -0,0 +1,155 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hashed tokens", func() {
+
+	secret := []byte("a secret of thirty-two bytes....")
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir", "test/Maildir2"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+			Junk: true,
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = SetTokenSecret(secret)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = HashTokens(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		SetTokenSecret(nil)
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+		err = os.RemoveAll("test/Maildir2")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Stores no words in cleartext", func() {
+		err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+			Ω(tx.Bucket([]byte("Wordlists")).Bucket([]byte("Junk")).Get([]byte("london"))).Should(BeNil())
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		meta, err := ReadMeta(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.TokenHash).ShouldNot(BeEmpty())
+	})
+
+	It("Classifies and learns with hashed words", func() {
+		_, _, _, jWords := Info(dbs["test/Maildir"])
+
+		answer, prob, err := Junk(dbs["test/Maildir"], []string{"london"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(prob).Should(Equal(1.0))
+		Ω(answer).Should(BeTrue())
+
+		// hashing twice changes nothing
+		err = HashTokens(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+
+		m = &Mail{
+			Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+			Junk: true,
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, _, _, j := Info(dbs["test/Maildir"])
+		Ω(j).Should(Equal(jWords))
+	})
+
+	It("Hashes the words of databases opened with a token secret", func() {
+		db, err := OpenDatabase("test/hashed.db")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.Remove("test/hashed.db")
+		defer db.Close()
+
+		meta, err := ReadMeta(db)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.TokenHash).ShouldNot(BeEmpty())
+	})
+
+	It("Leaves no words in the file of a database hashed when opened", func() {
+		SetTokenSecret(nil)
+		files, err := ioutil.ReadDir("test/Maildir/.Junk/cur")
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, f := range files {
+			m = &Mail{
+				Key:    f.Name(),
+				Folder: ".Junk",
+			}
+			err = m.Learn(dbs["test/Maildir2"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+		CloseDatabases(dbs)
+
+		SetTokenSecret(secret)
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		raw, err := ioutil.ReadFile("test/Maildir2/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(raw)).ShouldNot(ContainSubstring("london"))
+	})
+
+	It("Keeps the token secret outside the database", func() {
+		err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("Meta")).ForEach(func(k, v []byte) error {
+				Ω(string(v)).ShouldNot(ContainSubstring(string(secret)))
+				return nil
+			})
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Refuses databases without the secret they hash with", func() {
+		CloseDatabases(dbs)
+
+		err = SetTokenSecret([]byte("another secret of 32 bytes......"))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = OpenDatabase("test/Maildir")
+		Ω(err).Should(Equal(ErrUnknownTokenSecret))
+
+		SetTokenSecret(nil)
+		_, err = OpenDatabaseReadOnly("test/Maildir", time.Second)
+		Ω(err).Should(Equal(ErrTokenSecretRequired))
+
+		SetTokenSecret(secret)
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Refuses to merge databases hashing differently", func() {
+		err = Merge(dbs["test/Maildir2"], dbs["test/Maildir"])
+		Ω(err).Should(HaveOccurred())
+	})
+})
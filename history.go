@@ -0,0 +1,194 @@
+package sisyphus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/mail"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Actions taken on a classified mail, as recorded in the history
+const (
+	ActionNone    = "none"
+	ActionMoved   = "moved to junk"
+	ActionDryRun  = "dry run"
+	ActionRescued = "rescued"
+)
+
+// HistoryEntry records a classification decision. The subject is only kept
+// as a hash, so the history reveals no more of a mail's content than
+// necessary. Entries are encrypted like the learned model, see
+// SetEncryptionKeys. Score is nil if the mail could not be classified for lack of
+// learned words, or if Sender records the verdict of the sender lists.
+type HistoryEntry struct {
+	Time      time.Time `json:"time"`
+	Key       string    `json:"key"`
+	MessageID string    `json:"message_id"`
+	From      string    `json:"from"`
+	Subject   string    `json:"subject_hash"`
+	Score     *float64  `json:"score,omitempty"`
+	Junk      bool      `json:"junk"`
+	Sender    string    `json:"sender,omitempty"`
+	Action    string    `json:"action"`
+}
+
+// HistoryFilter selects history entries. Zero values match all entries; From
+// matches any sender containing it, ignoring case.
+type HistoryFilter struct {
+	Since, Until time.Time
+	From         string
+	Key          string
+	MessageID    string
+}
+
+// match reports whether the entry is selected by the filter
+func (f HistoryFilter) match(e HistoryEntry) bool {
+	switch {
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	case f.From != "" && !strings.Contains(strings.ToLower(e.From), strings.ToLower(f.From)):
+		return false
+	case f.Key != "" && e.Key != f.Key:
+		return false
+	case f.MessageID != "" && e.MessageID != f.MessageID:
+		return false
+	}
+
+	return true
+}
+
+// historyKey returns the key of an entry at time t, so entries sort by time
+func historyKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+
+	return key
+}
+
+// newHistoryEntry describes the classification of a mail with the given
+// header.
+func (m *Mail) newHistoryEntry(header *mail.Header, prob float64) HistoryEntry {
+	subject := sha256.Sum256([]byte(header.Get("Subject")))
+
+	e := HistoryEntry{
+		Time:      time.Now(),
+		Key:       m.Key,
+		MessageID: header.Get("Message-Id"),
+		From:      header.Get("From"),
+		Subject:   hex.EncodeToString(subject[:]),
+		Junk:      m.Junk,
+		Action:    ActionNone,
+	}
+	if !math.IsNaN(prob) {
+		e.Score = &prob
+	}
+
+	return e
+}
+
+// recordHistory appends an entry to the history in a Store
+func recordHistory(s Store, e HistoryEntry) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return s.Update(func(tx Tx) error {
+		key := historyKey(e.Time)
+
+		// entries recorded within the same nanosecond must not overwrite
+		// each other
+		for tx.Get("History", string(key)) != nil {
+			binary.BigEndian.PutUint64(key, binary.BigEndian.Uint64(key)+1)
+		}
+
+		// the Store encrypts the entry along with the model
+		return tx.Put("History", string(key), value)
+	})
+}
+
+// History returns all entries of the classification history selected by the
+// filter, oldest first.
+func History(db *bolt.DB, f HistoryFilter) (entries []HistoryEntry, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("History"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		k, v := c.First()
+		if !f.Since.IsZero() {
+			k, v = c.Seek(historyKey(f.Since))
+		}
+		for ; k != nil; k, v = c.Next() {
+			plain, err := decryptValue("History", string(k), v)
+			if err != nil {
+				return err
+			}
+
+			var e HistoryEntry
+			err = json.Unmarshal(plain, &e)
+			if err != nil {
+				return err
+			}
+
+			if f.match(e) {
+				entries = append(entries, e)
+			}
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// PruneHistory removes all entries of the classification history recorded
+// before the given time, and forgets about mails rescued before. It returns
+// the number of removed entries.
+func PruneHistory(db *bolt.DB, before time.Time) (n int, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("History")).Cursor()
+		end := historyKey(before)
+
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.First() {
+			err := c.Delete()
+			if err != nil {
+				return err
+			}
+			n++
+		}
+
+		r := tx.Bucket([]byte("Rescued"))
+		var old [][]byte
+		err := r.ForEach(func(k, v []byte) error {
+			if bytes.Compare(v, end) < 0 {
+				old = append(old, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys cannot be deleted while iterating
+		for _, k := range old {
+			err = r.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return n, err
+}
@@ -0,0 +1,131 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+
+	Context("Record classification decisions", func() {
+		BeforeEach(func() {
+			err = LoadMaildirs([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			raw, err := ioutil.ReadFile("test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M2P2.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:    "1500000000.M2P2.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/new/1500000000.M2P2.test")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Records the classified mail", func() {
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(1))
+			Ω(entries[0].Key).Should(Equal("1500000000.M2P2.test"))
+			Ω(entries[0].From).ShouldNot(BeEmpty())
+			Ω(entries[0].Score).Should(BeNil())
+			Ω(entries[0].Junk).Should(BeFalse())
+			Ω(entries[0].Action).Should(Equal(ActionNone))
+		})
+
+		It("Encrypts the entries along with the model", func() {
+			keys, err := ParseKeys("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = SetEncryptionKeys(keys)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer SetEncryptionKeys(nil)
+
+			CloseDatabases(dbs)
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("History")).ForEach(func(k, v []byte) error {
+					Ω(string(v)).Should(HavePrefix("SYE1"))
+					return nil
+				})
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(1))
+			Ω(entries[0].From).ShouldNot(BeEmpty())
+		})
+
+		It("Filters entries by time, sender and key", func() {
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{
+				Since: time.Now().Add(time.Hour),
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(BeEmpty())
+
+			entries, err = History(dbs["test/Maildir"], HistoryFilter{
+				From: "DOES NOT EXIST",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(BeEmpty())
+
+			entries, err = History(dbs["test/Maildir"], HistoryFilter{
+				Since: time.Now().Add(-time.Hour),
+				Key:   "1500000000.M2P2.test",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(1))
+		})
+
+		It("Removes entries beyond retention", func() {
+			// rescued mails point at the history entry of their rescue
+			err = dbs["test/Maildir"].Update(func(tx *bolt.Tx) error {
+				for _, k := range []string{"a", "b", "c", "d"} {
+					err := tx.Bucket([]byte("Rescued")).Put([]byte(k), []byte{0, 0, 0, 0, 0, 0, 0, 1})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := PruneHistory(dbs["test/Maildir"], time.Now())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(1))
+
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(BeEmpty())
+
+			err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+				Ω(tx.Bucket([]byte("Rescued")).Stats().KeyN).Should(BeZero())
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+})
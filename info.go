@@ -1,50 +1,170 @@
 package sisyphus
 
 import (
-	"github.com/boltdb/bolt"
-	"github.com/retailnext/hllpp"
+	"math"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
-// Info produces statistics
+// info reads the number of learned mails and words. Count-min sketches do
+// not store words, so their counters in use are reported instead.
+func info(tx Tx) (gTotal, jTotal, gWords, jWords uint64, err error) {
+	gTotal, jTotal, err = mailCounts(tx, "")
+	gWords = uint64(tx.Len("Wordlists/Good"))
+	jWords = uint64(tx.Len("Wordlists/Junk"))
+
+	return gTotal, jTotal, gWords, jWords, err
+}
+
+// Info produces statistics. Corrupt statistics count as no mails; Stats
+// reports them and Fsck repairs them.
 func Info(db *bolt.DB) (gTotal, jTotal, gWords, jWords uint64) {
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		p := tx.Bucket([]byte("Statistics"))
-		gRaw := p.Get([]byte("ProcessedGood"))
-		if len(gRaw) > 0 {
-			var gHLL *hllpp.HLLPP
-			gHLL, _ = hllpp.Unmarshal(gRaw)
-			gTotal = gHLL.Count()
+	_ = NewBoltStore(db).View(func(tx Tx) error {
+		gTotal, jTotal, gWords, jWords, _ = info(tx)
+		return nil
+	})
+
+	return gTotal, jTotal, gWords, jWords
+}
+
+// topTokens is the number of most indicative tokens reported in statistics
+const topTokens = 10
+
+// Token is a learned word together with its counts and the probability of a
+// mail containing it being junk.
+type Token struct {
+	Word  string  `json:"word"`
+	Good  uint64  `json:"good"`
+	Junk  uint64  `json:"junk"`
+	Score float64 `json:"score"`
+}
+
+// Statistics summarizes what has been learned in a database and how mails
+// were classified recently.
+type Statistics struct {
+	GoodMails   uint64         `json:"good_mails"`
+	JunkMails   uint64         `json:"junk_mails"`
+	GoodWords   uint64         `json:"good_words"`
+	JunkWords   uint64         `json:"junk_words"`
+	GoodHapaxes uint64         `json:"good_hapaxes"`
+	JunkHapaxes uint64         `json:"junk_hapaxes"`
+	TopGood     []Token        `json:"top_good"`
+	TopJunk     []Token        `json:"top_junk"`
+	Scores      []uint64       `json:"scores"`
+	Size        int64          `json:"size"`
+	LastLearned time.Time      `json:"last_learned"`
+	PrunedWords int            `json:"pruned_words"`
+	Folders     map[string]int `json:"folders"`
+}
+
+// wordCounts returns the number of mails each word of a Wordlists bucket has
+// been learned from. Count-min sketches do not store their words, so there
+// are none.
+func wordCounts(tx Tx, class string) (counts map[string]uint64, err error) {
+	counts = make(map[string]uint64)
+
+	c, err := countingOf(tx)
+	if err != nil || c.Model == CountMin {
+		return counts, err
+	}
+
+	bucket := "Wordlists/" + class
+	err = tx.ForEach(bucket, func(k string, _ []byte) (err error) {
+		counts[k], err = countOf(tx, bucket, k)
+		return err
+	})
+
+	return counts, err
+}
+
+// tokens produces the indicative tokens of a database: the words most
+// likely to be found in good mails and in junk mails, respectively.
+func (s *Statistics) tokens(tx Tx) error {
+	good, err := wordCounts(tx, "Good")
+	if err != nil {
+		return err
+	}
+	junk, err := wordCounts(tx, "Junk")
+	if err != nil {
+		return err
+	}
+
+	var tokens []Token
+	for w, n := range good {
+		tokens = append(tokens, Token{Word: w, Good: n, Junk: junk[w]})
+		if n == 1 {
+			s.GoodHapaxes++
 		}
-		jRaw := p.Get([]byte("ProcessedJunk"))
-		if len(jRaw) > 0 {
-			var jHLL *hllpp.HLLPP
-			jHLL, _ = hllpp.Unmarshal(jRaw)
-			jTotal = jHLL.Count()
+	}
+	for w, n := range junk {
+		if _, ok := good[w]; !ok {
+			tokens = append(tokens, Token{Word: w, Junk: n})
 		}
+		if n == 1 {
+			s.JunkHapaxes++
+		}
+	}
 
+	if s.GoodMails == 0 || s.JunkMails == 0 {
 		return nil
-	})
+	}
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		p := tx.Bucket([]byte("Wordlists"))
-		pj := p.Bucket([]byte("Junk"))
+	for i, t := range tokens {
+		g := float64(t.Good) / float64(s.GoodMails)
+		j := float64(t.Junk) / float64(s.JunkMails)
+		tokens[i].Score = j / (g + j)
+	}
 
-		stats := pj.Stats()
-		jWords = uint64(stats.KeyN)
+	// most junk first, more evidence wins a tie
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Score != tokens[j].Score {
+			return tokens[i].Score > tokens[j].Score
+		}
+		return tokens[i].Good+tokens[i].Junk > tokens[j].Good+tokens[j].Junk
+	})
+	n := int(math.Min(topTokens, float64(len(tokens))))
+	s.TopJunk = append([]Token{}, tokens[:n]...)
 
-		return nil
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Score < tokens[j].Score
 	})
+	s.TopGood = append([]Token{}, tokens[:n]...)
+
+	return nil
+}
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		p := tx.Bucket([]byte("Wordlists"))
-		pg := p.Bucket([]byte("Good"))
+// Stats produces the statistics of a Maildir and its database
+func (d Maildir) Stats(db *bolt.DB) (s Statistics, err error) {
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		s.GoodMails, s.JunkMails, s.GoodWords, s.JunkWords, err = info(boltTx{tx: tx})
+		if err != nil {
+			return err
+		}
 
-		stats := pg.Stats()
-		gWords = uint64(stats.KeyN)
+		s.Size = tx.Size()
 
-		return nil
+		meta, err := readMeta(tx)
+		if err != nil {
+			return err
+		}
+		s.LastLearned = meta.LastLearned
+		s.PrunedWords = meta.PrunedTokens
+
+		s.Scores, err = scoreHistogram(tx)
+		if err != nil {
+			return err
+		}
+
+		return s.tokens(boltTx{tx: tx})
 	})
+	if err != nil {
+		return s, err
+	}
 
-	return gTotal, jTotal, gWords, jWords
+	s.Folders, err = d.FolderCounts()
+
+	return s, err
 }
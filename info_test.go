@@ -0,0 +1,88 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Info", func() {
+
+	Context("Statistics of a database", func() {
+		BeforeEach(func() {
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Reports learned mails, words and indicative tokens", func() {
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(s.GoodMails).Should(Equal(uint64(1)))
+			Ω(s.JunkMails).Should(Equal(uint64(1)))
+			Ω(s.GoodHapaxes).Should(Equal(s.GoodWords))
+			Ω(s.JunkHapaxes).Should(Equal(s.JunkWords))
+			Ω(s.TopJunk).Should(HaveLen(10))
+			Ω(s.TopJunk[0].Score).Should(Equal(1.0))
+			Ω(s.TopGood).Should(HaveLen(10))
+			Ω(s.TopGood[0].Score).Should(Equal(0.0))
+			Ω(s.LastLearned.IsZero()).Should(BeFalse())
+			Ω(s.Size).Should(BeNumerically(">", 0))
+		})
+
+		It("Reports the distribution of recent classification scores", func() {
+			raw, err := ioutil.ReadFile("test/Maildir/.Junk/cur/1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = LoadMaildirs([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M1P1.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove("test/Maildir/new/1500000000.M1P1.test")
+
+			m = &Mail{
+				Key:    "1500000000.M1P1.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Scores).Should(HaveLen(10))
+			Ω(s.Scores[9]).Should(Equal(uint64(1)))
+		})
+
+		It("Counts the mails in each folder", func() {
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(s.Folders).Should(Equal(map[string]int{
+				"INBOX": 1,
+				".Junk": 10,
+			}))
+		})
+	})
+})
@@ -0,0 +1,268 @@
+package sisyphus
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// LanguageNone keeps words as they are.
+	LanguageNone = "none"
+
+	// LanguageAuto detects the language of every mail by the stop words
+	// it contains. Mails of no known language keep their words.
+	LanguageAuto = "auto"
+
+	// LanguageEnglish reduces English words to their stems.
+	LanguageEnglish = "en"
+
+	// LanguageGerman reduces German words to their stems.
+	LanguageGerman = "de"
+
+	// LanguageFrench reduces French words to their stems.
+	LanguageFrench = "fr"
+
+	// LanguageItalian reduces Italian words to their stems.
+	LanguageItalian = "it"
+
+	// LanguageSpanish reduces Spanish words to their stems.
+	LanguageSpanish = "es"
+)
+
+// minStopWords is the number of stop words a mail needs to contain for its
+// language to be detected
+const minStopWords = 3
+
+// language reduces the words of a language to their stems. Stop words are
+// too common to tell anything about a mail and are dropped.
+type language struct {
+	stem      func(word string) string
+	stopWords map[string]bool
+}
+
+// wordSet returns the space separated words of s as a set
+func wordSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+
+	return set
+}
+
+// knownLanguages are the languages words can be reduced in, by their code.
+// The stop words are taken from the Snowball project, with their accents
+// removed like those of mails.
+var knownLanguages = map[string]language{
+	LanguageEnglish: {stem: stemEnglish, stopWords: wordSet(englishStopWords)},
+	LanguageGerman:  {stem: stemGerman, stopWords: wordSet(germanStopWords)},
+	LanguageFrench:  {stem: stemFrench, stopWords: wordSet(frenchStopWords)},
+	LanguageItalian: {stem: stemItalian, stopWords: wordSet(italianStopWords)},
+	LanguageSpanish: {stem: stemSpanish, stopWords: wordSet(spanishStopWords)},
+}
+
+// detectable are the codes of the languages detected, which wins a tie first
+var detectable = []string{
+	LanguageEnglish, LanguageGerman, LanguageFrench, LanguageItalian,
+	LanguageSpanish,
+}
+
+// languages are the languages the databases of Maildirs reduce words in
+var languages = maildirSetting{all: LanguageNone, dirs: make(map[string]string)}
+
+// ParseLanguage checks the language to reduce words in: none, auto or the
+// code of a known language, i.e. en, de, fr, it or es
+func ParseLanguage(s string) (string, error) {
+	if _, ok := knownLanguages[s]; ok || s == LanguageNone || s == LanguageAuto {
+		return s, nil
+	}
+
+	return s, fmt.Errorf("unknown language %q", s)
+}
+
+// SetLanguage sets the language the database of a Maildir reduces words in
+// from the time it is next opened, or that of all Maildirs not given their
+// own if d is empty. An empty language makes the Maildir take that of all
+// others again. Words learned before are not reduced, so a database should
+// be rebuilt after its language changed.
+func SetLanguage(d Maildir, l string) error {
+	if d == "" || l != "" {
+		_, err := ParseLanguage(l)
+		if err != nil {
+			return err
+		}
+	}
+
+	languages.set(d, l)
+
+	return nil
+}
+
+// Language returns the language the database of the Maildir reduces words in
+func (d Maildir) Language() string {
+	return languages.of(settingPath(d))
+}
+
+// setTokenizer records the features and the language set for the database
+// at path in the database db, which splits mails accordingly from now on
+func setTokenizer(db *bolt.DB, path string) error {
+	err := setMeta(db, "Features", features.of(path), FeatureWords)
+	if err != nil {
+		return err
+	}
+
+	return setMeta(db, "Language", languages.of(path), LanguageNone)
+}
+
+// detectLanguage returns the code of the known language whose stop words
+// are used most in a cleaned text, or LanguageNone if there are too few.
+func detectLanguage(text string) string {
+	hits := make(map[string]int)
+	for _, w := range strings.Fields(text) {
+		for _, code := range detectable {
+			if knownLanguages[code].stopWords[w] {
+				hits[code]++
+			}
+		}
+	}
+
+	best := LanguageNone
+	for _, code := range detectable {
+		if hits[code] >= minStopWords && hits[code] > hits[best] {
+			best = code
+		}
+	}
+
+	return best
+}
+
+// stemWords drops the stop words of a language from words and reduces the
+// others to their stems. Words of other scripts are kept as they are, and
+// so are all words if the language is not known.
+func stemWords(words []string, code string) []string {
+	l, ok := knownLanguages[code]
+	if !ok {
+		return words
+	}
+
+	stemmed := make([]string, 0, len(words))
+	for _, w := range words {
+		if l.stopWords[w] {
+			continue
+		}
+		if w[0] < utf8.RuneSelf {
+			w = l.stem(w)
+		}
+		stemmed = append(stemmed, w)
+	}
+
+	return stemmed
+}
+
+const englishStopWords = `
+i me my myself we our ours ourselves you your yours yourself yourselves he
+him his himself she her hers herself it its itself they them their theirs
+themselves what which who whom this that these those am is are was were be
+been being have has had having do does did doing would should could ought a
+an the and but if or because as until while of at by for with about against
+between into through during before after above below to from up down in out
+on off over under again further then once here there when where why how all
+any both each few more most other some such no nor not only own same so than
+too very will shall can just don now
+`
+
+const germanStopWords = `
+aber alle allem allen aller alles als also am an ander andere anderem anderen
+anderer anderes anderm andern anderr anders auch auf aus bei bin bis bist da
+damit dann der den des dem die das dass derselbe derselben denselben
+desselben demselben dieselbe dieselben dasselbe dazu dein deine deinem deinen
+deiner deines denn derer dessen dich dir du dies diese diesem diesen dieser
+dieses doch dort durch ein eine einem einen einer eines einig einige einigem
+einigen einiger einiges einmal er ihn ihm es etwas euer eure eurem euren
+eurer eures fuer gegen gewesen hab habe haben hat hatte hatten hier hin
+hinter ich mich mir ihr ihre ihrem ihren ihrer ihres euch im in indem ins ist
+jede jedem jeden jeder jedes jene jenem jenen jener jenes jetzt kann kein
+keine keinem keinen keiner keines koennen koennte machen man manche manchem
+manchen mancher manches mein meine meinem meinen meiner meines mit muss
+musste nach nicht nichts noch nun nur ob oder ohne sehr sein seine seinem
+seinen seiner seines selbst sich sie ihnen sind so solche solchem solchen
+solcher solches soll sollte sondern sonst ueber um und uns unsere unserem
+unseren unser unseres unter viel vom von vor waehrend war waren warst was weg
+weil weiter welche welchem welchen welcher welches wenn werde werden wie
+wieder will wir wird wirst wo wollen wollte wuerde wuerden zu zum zur zwar
+zwischen
+`
+
+const frenchStopWords = `
+au aux avec ce ces dans de des du elle en et eux il ils je la le les leur lui
+ma mais me meme mes moi mon ne nos notre nous on ou par pas pour qu que qui
+sa se ses son sur ta te tes toi ton tu un une vos votre vous c d j l a m n s
+t y ete etee etees etes etant etante etants etantes suis es est sommes sont
+serai seras sera serons serez seront serais serait serions seriez seraient
+etais etait etions etiez etaient fus fut fumes futes furent sois soit soyons
+soyez soient fusse fusses fussions fussiez fussent ayant ayante ayantes
+ayants eu eue eues eus ai as avons avez ont aurai auras aura aurons aurez
+auront aurais aurait aurions auriez auraient avais avait avions aviez
+avaient eut eumes eutes eurent aie aies ait ayons ayez aient eusse eusses
+eussions eussiez eussent
+`
+
+const italianStopWords = `
+ad al allo ai agli all agl alla alle con col coi da dal dallo dai dagli dall
+dagl dalla dalle di del dello dei degli dell degl della delle in nel nello
+nei negli nell negl nella nelle su sul sullo sui sugli sull sugl sulla sulle
+per tra contro io tu lui lei noi voi loro mio mia miei mie tuo tua tuoi tue
+suo sua suoi sue nostro nostra nostri nostre vostro vostra vostri vostre mi
+ti ci vi lo la li le gli ne il un uno una ma ed se perche anche come dov dove
+che chi cui non piu quale quanto quanti quanta quante quello quelli quella
+quelle questo questi questa queste si tutto tutti a c e i l o ho hai ha
+abbiamo avete hanno abbia abbiate abbiano avro avrai avra avremo avrete
+avranno avrei avresti avrebbe avremmo avreste avrebbero avevo avevi aveva
+avevamo avevate avevano ebbi avesti ebbe avemmo aveste ebbero avessi avesse
+avessimo avessero avendo avuto avuta avuti avute sono sei siamo siete sia
+siate siano saro sarai sara saremo sarete saranno sarei saresti sarebbe
+saremmo sareste sarebbero ero eri era eravamo eravate erano fui fosti fu
+fummo foste furono fossi fosse fossimo fossero essendo faccio fai facciamo
+fanno faccia facciate facciano faro farai fara faremo farete faranno farei
+faresti farebbe faremmo fareste farebbero facevo facevi faceva facevamo
+facevate facevano feci facesti fece facemmo faceste fecero facessi facesse
+facessimo facessero facendo sto stai sta stiamo stanno stia stiate stiano
+staro starai stara staremo starete staranno starei staresti starebbe
+staremmo stareste starebbero stavo stavi stava stavamo stavate stavano
+stetti stesti stette stemmo steste stettero stessi stesse stessimo stessero
+stando
+`
+
+const spanishStopWords = `
+de la que el en y a los del se las por un para con no una su al lo como mas
+pero sus le ya o este si porque esta entre cuando muy sin sobre tambien me
+hasta hay donde quien desde todo nos durante todos uno les ni contra otros
+ese eso ante ellos e esto mi antes algunos unos yo otro otras otra tanto esa
+estos mucho quienes nada muchos cual poco ella estar estas algunas algo
+nosotros mis tu te ti tus ellas nosotras vosotros vosotras os mio mia mios
+mias tuyo tuya tuyos tuyas suyo suya suyos suyas nuestro nuestra nuestros
+nuestras vuestro vuestra vuestros vuestras esos esas estoy estamos estais
+estan estes estemos esteis esten estare estaras estara estaremos estareis
+estaran estaria estarias estariamos estariais estarian estaba estabas
+estabamos estabais estaban estuve estuviste estuvo estuvimos estuvisteis
+estuvieron estuviera estuvieras estuvieramos estuvierais estuvieran
+estuviese estuvieses estuviesemos estuvieseis estuviesen estando estado
+estada estados estadas estad he has ha hemos habeis han haya hayas hayamos
+hayais hayan habre habras habra habremos habreis habran habria habrias
+habriamos habriais habrian habia habias habiamos habiais habian hube
+hubiste hubo hubimos hubisteis hubieron hubiera hubieras hubieramos
+hubierais hubieran hubiese hubieses hubiesemos hubieseis hubiesen habiendo
+habido habida habidos habidas soy eres es somos sois son sea seas seamos
+seais sean sere seras sera seremos sereis seran seria serias seriamos
+seriais serian era eras eramos erais eran fui fuiste fue fuimos fuisteis
+fueron fuera fueras fueramos fuerais fueran fuese fueses fuesemos fueseis
+fuesen siendo sido tengo tienes tiene tenemos teneis tienen tenga tengas
+tengamos tengais tengan tendre tendras tendra tendremos tendreis tendran
+tendria tendrias tendriamos tendriais tendrian tenia tenias teniamos
+teniais tenian tuve tuviste tuvo tuvimos tuvisteis tuvieron tuviera
+tuvieras tuvieramos tuvierais tuvieran tuviese tuvieses tuviesemos
+tuvieseis tuviesen teniendo tenido tenida tenidos tenidas tened
+`
@@ -0,0 +1,192 @@
+package sisyphus_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Language", func() {
+
+	const german = "Subject: Unsere besten Angebote\n\nBestellen Sie jetzt unsere guenstigen Angebote und sparen Sie mit unseren Angeboten\n"
+	const english = "Subject: Your offers\n\nThanks for the offers you sent me yesterday, they were really interesting and I will read them again\n"
+
+	var (
+		dir string
+		db  *bolt.DB
+	)
+
+	// learn opens the database in dir and learns a German junk and an
+	// English good mail
+	learn := func() {
+		db, err = OpenDatabase(dir)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		for _, m := range []struct {
+			file, text string
+			junk       bool
+		}{
+			{"junk.mbox", german, true},
+			{"good.mbox", english, false},
+		} {
+			path := filepath.Join(dir, m.file)
+			err = ioutil.WriteFile(path, []byte("From sisyphus@example.com Thu Jan  1 00:00:00 2015\n"+m.text), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := LearnMbox(db, path, m.junk, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(1))
+		}
+	}
+
+	// words returns the words learned from the mails of a class
+	words := func(class string) map[string]bool {
+		var buf bytes.Buffer
+		err := Export(db, &buf)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		w := make(map[string]bool)
+		dec := json.NewDecoder(&buf)
+		dec.Decode(&ExportHeader{})
+		for dec.More() {
+			var r ExportRecord
+			err = dec.Decode(&r)
+			Ω(err).ShouldNot(HaveOccurred())
+			if r.Bucket == "Wordlists/"+class {
+				w[r.Key] = true
+			}
+		}
+
+		return w
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "sisyphus")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		SetLanguage("", LanguageNone)
+		SetLanguage(Maildir(dir), "")
+		if db != nil {
+			db.Close()
+			db = nil
+		}
+
+		os.RemoveAll(dir)
+	})
+
+	It("Parses languages", func() {
+		for _, s := range []string{"none", "auto", "en", "de", "fr", "it", "es"} {
+			l, err := ParseLanguage(s)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(Equal(s))
+		}
+
+		_, err = ParseLanguage("tlh")
+		Ω(err).Should(HaveOccurred())
+
+		err = SetLanguage("", "")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Keeps words as they are by default", func() {
+		learn()
+
+		meta, err := ReadMeta(db)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Language).Should(Equal(LanguageNone))
+
+		junk := words("Junk")
+		Ω(junk).Should(HaveKey("angebote"))
+		Ω(junk).Should(HaveKey("angeboten"))
+		Ω(junk).Should(HaveKey("unsere"))
+	})
+
+	It("Reduces the words of the language set to their stems", func() {
+		err = SetLanguage("", LanguageGerman)
+		Ω(err).ShouldNot(HaveOccurred())
+		learn()
+
+		meta, err := ReadMeta(db)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(meta.Language).Should(Equal(LanguageGerman))
+
+		junk := words("Junk")
+		Ω(junk).Should(HaveKey("angebot"))
+		Ω(junk).Should(HaveKey("bestell"))
+		Ω(junk).ShouldNot(HaveKey("angebote"))
+		Ω(junk).ShouldNot(HaveKey("unsere"))
+
+		answer, prob, err := Junk(db, []string{"angebot"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(prob).Should(Equal(1.0))
+		Ω(answer).Should(BeTrue())
+	})
+
+	It("Reduces the words of all known languages", func() {
+		for code, c := range map[string]struct {
+			text  string
+			stems []string
+		}{
+			LanguageFrench:  {"mangeons les offres des chevaux", []string{"mangeon", "offre", "cheval"}},
+			LanguageItalian: {"mangiare gattini offerte", []string{"mang", "gattin", "offert"}},
+			LanguageSpanish: {"corriendo con ofertas abandonada", []string{"corr", "ofert", "abandon"}},
+		} {
+			err = SetLanguage("", code)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			db, err = OpenDatabase(filepath.Join(dir, code+".db"))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			path := filepath.Join(dir, code+".mbox")
+			err = ioutil.WriteFile(path, []byte("From sisyphus@example.com Thu Jan  1 00:00:00 2015\nSubject: "+code+"\n\n"+c.text+"\n"), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = LearnMbox(db, path, true, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			junk := words("Junk")
+			for _, stem := range c.stems {
+				Ω(junk).Should(HaveKey(stem))
+			}
+
+			db.Close()
+		}
+		db = nil
+	})
+
+	It("Detects the language of every mail", func() {
+		err = SetLanguage(Maildir(dir), LanguageAuto)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(Maildir(dir).Language()).Should(Equal(LanguageAuto))
+		learn()
+
+		junk := words("Junk")
+		Ω(junk).Should(HaveKey("angebot"))
+		Ω(junk).ShouldNot(HaveKey("unsere"))
+
+		good := words("Good")
+		Ω(good).Should(HaveKey("offer"))
+		Ω(good).Should(HaveKey("realli"))
+		Ω(good).ShouldNot(HaveKey("they"))
+	})
+
+	It("Reduces the words pairs are formed from", func() {
+		err = SetLanguage("", LanguageEnglish)
+		Ω(err).ShouldNot(HaveOccurred())
+		err = SetFeatures("", FeatureBigrams)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer SetFeatures("", FeatureWords)
+		learn()
+
+		Ω(words("Good")).Should(HaveKey("b:thank offer"))
+	})
+})
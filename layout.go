@@ -0,0 +1,112 @@
+package sisyphus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Layout names the Maildir++ folders sisyphus works with. Junk is the folder
+// junk mails are moved to, and Sent the folder whose recipients are allowed.
+// Besides the inbox and the Junk folder, mails are learned from the folders
+// matching the globs in Good as good and from those matching JunkSources as
+// junk, unless they match a glob in Exclude.
+type Layout struct {
+	Junk        string
+	Sent        string
+	Good        []string
+	JunkSources []string
+	Exclude     []string
+}
+
+// DefaultLayout only learns from the inbox and the .Junk folder
+var DefaultLayout = Layout{
+	Junk: ".Junk",
+	Sent: ".Sent",
+}
+
+// layout is the Layout used for all Maildirs
+var layout = DefaultLayout
+
+// SetLayout sets the Layout used for all Maildirs.
+func SetLayout(l Layout) error {
+	for _, f := range []string{l.Junk, l.Sent} {
+		if !strings.HasPrefix(f, ".") || strings.ContainsRune(f, filepath.Separator) {
+			return fmt.Errorf("invalid Maildir++ folder %q", f)
+		}
+	}
+
+	for _, globs := range [][]string{l.Good, l.JunkSources, l.Exclude} {
+		for _, g := range globs {
+			_, err := filepath.Match(g, "")
+			if err != nil {
+				return fmt.Errorf("invalid folder pattern %q", g)
+			}
+		}
+	}
+
+	layout = l
+
+	return nil
+}
+
+// matchFolder reports whether a folder name matches any of the globs
+func matchFolder(globs []string, name string) bool {
+	for _, g := range globs {
+		ok, _ := filepath.Match(g, name)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// learnFolder is a folder of a Maildir that mails are learned from. The
+// inbox has no name.
+type learnFolder struct {
+	name string
+	junk bool
+}
+
+// learnFolders returns the inbox, the Junk folder and all Maildir++ folders
+// selected by the Layout.
+func (d Maildir) learnFolders() (folders []learnFolder, err error) {
+	folders = []learnFolder{
+		{name: ""},
+		{name: layout.Junk, junk: true},
+	}
+
+	f, err := os.Open(string(d))
+	if err != nil {
+		return folders, err
+	}
+	names, err := f.Readdirnames(0)
+	f.Close()
+	if err != nil {
+		return folders, err
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if !strings.HasPrefix(n, ".") || n == layout.Junk || matchFolder(layout.Exclude, n) {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(string(d), n, "cur"))
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		switch {
+		case matchFolder(layout.JunkSources, n):
+			folders = append(folders, learnFolder{name: n, junk: true})
+		case matchFolder(layout.Good, n):
+			folders = append(folders, learnFolder{name: n})
+		}
+	}
+
+	return folders, nil
+}
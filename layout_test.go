@@ -0,0 +1,100 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Layout", func() {
+
+	Context("Set a layout", func() {
+		AfterEach(func() {
+			err = SetLayout(DefaultLayout)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Refuses invalid folders and patterns", func() {
+			err = SetLayout(Layout{Junk: "Spam", Sent: ".Sent"})
+			Ω(err).Should(HaveOccurred())
+			err = SetLayout(Layout{Junk: ".Spam", Sent: ".Sent", Good: []string{"["}})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Learn from Maildir++ folders", func() {
+		BeforeEach(func() {
+			raw, err := ioutil.ReadFile("test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			for _, f := range []string{".Archive.2023", ".Archive.Private", ".Spam"} {
+				err = os.MkdirAll("test/Maildir/"+f+"/cur", 0700)
+				Ω(err).ShouldNot(HaveOccurred())
+				err = ioutil.WriteFile("test/Maildir/"+f+"/cur/1500000000.M7P7.test:2,S", raw, 0600)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			err = SetLayout(Layout{
+				Junk:    ".Spam",
+				Sent:    ".Sent",
+				Good:    []string{".Archive.*"},
+				Exclude: []string{".Archive.Private"},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			err = SetLayout(DefaultLayout)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			for _, f := range []string{".Archive.2023", ".Archive.Private", ".Spam"} {
+				err = os.RemoveAll("test/Maildir/" + f)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+		})
+
+		It("Indexes the junk folder and included folders", func() {
+			mails, err := Maildir("test/Maildir").Index()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(mails).Should(ConsistOf(
+				&Mail{
+					Key:   "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+					Flags: "Sa",
+				},
+				&Mail{
+					Key:   "1500000000.M7P7.test",
+					Flags: "S",
+					Junk:  true,
+				},
+				&Mail{
+					Key:    "1500000000.M7P7.test",
+					Folder: ".Archive.2023",
+					Flags:  "S",
+				},
+			))
+		})
+
+		It("Loads mails from included folders", func() {
+			m = &Mail{
+				Key:    "1500000000.M7P7.test",
+				Folder: ".Archive.2023",
+			}
+			err = m.Load("test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(*m.Subject).ShouldNot(BeEmpty())
+		})
+
+		It("Counts mails per folder", func() {
+			counts, err := Maildir("test/Maildir").FolderCounts()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(counts).Should(Equal(map[string]int{
+				"INBOX":         1,
+				".Spam":         1,
+				".Archive.2023": 1,
+			}))
+		})
+	})
+})
@@ -1,76 +1,148 @@
 package sisyphus
 
 import (
+	"strings"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
-	"github.com/boltdb/bolt"
-	"github.com/retailnext/hllpp"
+	bolt "go.etcd.io/bbolt"
 )
 
-// learnWordlist adds the mail key to the respective word's list
-func (m *Mail) learnWordlist(w string, db *bolt.DB) error {
-	wordKey := "Good"
-	if m.Junk {
-		wordKey = "Junk"
+// learnPrefixes returns the paths of the buckets a mail is learned into: the
+// all-time buckets, and those of its period if a Decay is set.
+func (m *Mail) learnPrefixes() []string {
+	if !decay.periodic() {
+		return []string{""}
 	}
 
-	err := db.Update(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte("Wordlists"))
+	return []string{"", periodPrefix(m.period())}
+}
 
-		bucket := b.Bucket([]byte(wordKey))
-		wordRaw := bucket.Get([]byte(w))
-		var word *hllpp.HLLPP
-		if len(wordRaw) == 0 {
-			word = hllpp.New()
-		} else {
-			word, err = hllpp.Unmarshal(wordRaw)
-			if err != nil {
-				return err
-			}
+// learnedPrefixes returns the paths of the buckets a mail may have been
+// learned into: the all-time buckets, and those of its period if the store
+// holds that period, whether or not a Decay is set now.
+func (m *Mail) learnedPrefixes(tx Tx) []string {
+	prefixes := []string{""}
+	for _, p := range periods(tx) {
+		if p == m.period() {
+			prefixes = append(prefixes, periodPrefix(p))
 		}
+	}
 
-		word.Add([]byte(m.Key))
+	return prefixes
+}
 
-		err = bucket.Put([]byte(w), word.Marshal())
+// class returns the name of the class the mail is learned into
+func (m *Mail) class() string {
+	if m.Junk {
+		return "Junk"
+	}
 
-		return err
-	})
+	return "Good"
+}
 
-	return err
+// otherClass returns the class a class is not
+func otherClass(class string) string {
+	if class == "Junk" {
+		return "Good"
+	}
+
+	return "Junk"
 }
 
-// learnStatistics adds the mail key to the respective word's list
-func (m *Mail) learnStatistics(db *bolt.DB) error {
-	err := db.Update(func(tx *bolt.Tx) (err error) {
-		p := tx.Bucket([]byte("Statistics"))
+// uniqueWords returns the words of a list without duplicates, so each word
+// counts a mail once
+func uniqueWords(list []string) (words []string) {
+	seen := make(map[string]bool)
+	for _, w := range list {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+
+	return words
+}
 
-		key := "ProcessedGood"
-		if m.Junk {
-			key = "ProcessedJunk"
+// uncount removes n mails of a class from the counts of the words and the
+// statistics below prefix.
+func uncount(tx Tx, prefix, class string, words []string, n uint64) error {
+	for _, w := range words {
+		err := removeCount(tx, prefix+"Wordlists/"+class, tokenKey(tx, w), n)
+		if err != nil {
+			return err
 		}
+	}
 
-		keyRaw := p.Get([]byte(key))
-		var counter *hllpp.HLLPP
-		if len(keyRaw) == 0 {
-			counter = hllpp.New()
-		} else {
-			counter, err = hllpp.Unmarshal(keyRaw)
-			if err != nil {
-				return err
-			}
+	return removeCount(tx, prefix+"Statistics", "Processed"+class, n)
+}
+
+// newKeys returns the learn keys of the mail not yet learned into its class
+// below prefix, as recorded in its Learned bucket. If uncounting, keys
+// learned into the other class are unlearned from it first, so a mail moved
+// between classes is not counted in both.
+func (m *Mail) newKeys(tx Tx, prefix string, words []string, uncounting bool) (keys []string, err error) {
+	class := m.class()
+
+	var moved uint64
+	for _, k := range m.learnKeys() {
+		switch string(tx.Get(prefix+"Learned", k)) {
+		case class:
+			continue
+		case otherClass(class):
+			moved++
 		}
+		keys = append(keys, k)
+	}
 
-		counter.Add([]byte(m.Key))
+	if moved > 0 && uncounting {
+		err = uncount(tx, prefix, otherClass(class), words, moved)
+	}
 
-		err = p.Put([]byte(key), counter.Marshal())
+	return keys, err
+}
 
-		return err
-	})
+// learnInto counts the mail for its words and in the statistics below
+// prefix. It reports whether the mail was counted, which is skipped if it
+// was learned into its class before. So words dropped since, see
+// PruneTokens, do not come back when the mail is learned again.
+func (m *Mail) learnInto(tx Tx, prefix string, words []string) (counted bool, err error) {
+	c, err := countingOf(tx)
+	if err != nil {
+		return false, err
+	}
 
-	return err
+	keys, err := m.newKeys(tx, prefix, words, c.identities())
+	if err != nil || len(keys) == 0 {
+		return false, err
+	}
+
+	class := m.class()
+	for _, w := range words {
+		err = addCount(tx, prefix+"Wordlists/"+class, tokenKey(tx, w), keys)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = addCount(tx, prefix+"Statistics", "Processed"+class, keys)
+	if err != nil {
+		return false, err
+	}
+
+	for _, k := range keys {
+		err = tx.Put(prefix+"Learned", k, []byte(class))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
 }
 
-// Learn adds the the mail key to the list of words using hyper log log algorithm.
+// Learn counts the mail for each of its words in the database, using the
+// counting model the database was created with, see SetCounting.
 func (m *Mail) Learn(db *bolt.DB, dir Maildir) (err error) {
 
 	log.WithFields(log.Fields{
@@ -78,32 +150,130 @@ func (m *Mail) Learn(db *bolt.DB, dir Maildir) (err error) {
 		"mail": m.Key,
 	}).Info("Learn mail")
 
+	return m.LearnStore(NewBoltStore(db), dir)
+}
+
+// LearnStore works like Learn, but learns into any Store, e.g. one in memory
+// to evaluate the classifier.
+func (m *Mail) LearnStore(s Store, dir Maildir) (err error) {
+
 	err = m.Load(dir)
 	if err != nil {
 		return err
 	}
 
-	list, err := m.cleanWordlist()
+	err = m.learn(s, string(dir))
 	if err != nil {
 		return err
 	}
 
-	// Learn words
-	for _, val := range list {
-		err = m.learnWordlist(val, db)
-		if err != nil {
-			return err
+	return m.Unload(dir)
+}
+
+// learn adds the words of a loaded mail to the store. The mail's origin, e.g.
+// its Maildir, labels the metrics.
+func (m *Mail) learn(s Store, origin string) (err error) {
+
+	t, err := storeTokenizer(s)
+	if err != nil {
+		return err
+	}
+
+	list, err := m.cleanWordlist(t)
+	if err != nil {
+		return err
+	}
+	words := uniqueWords(list)
+
+	var counted bool
+	err = s.Update(func(tx Tx) error {
+		counted = false
+		for _, prefix := range m.learnPrefixes() {
+			c, err := m.learnInto(tx, prefix, words)
+			if err != nil {
+				return err
+			}
+			counted = counted || c
 		}
+
+		return tx.Put("Meta", "LastLearned", []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	if err != nil || !counted {
+		return err
+	}
+
+	mailsLearned.WithLabelValues(origin, strings.ToLower(m.class())).Inc()
+
+	return nil
+}
+
+// Unlearn removes a mail learned before from the database, as if it had
+// never been learned. This requires a counting model that can take mails out
+// of its counts, see CountExact and CountMin; mails not learned are
+// left alone. Pairs of words are only removed if the database learned the
+// same features when the mail was learned.
+func (m *Mail) Unlearn(db *bolt.DB, dir Maildir) (err error) {
+
+	log.WithFields(log.Fields{
+		"dir":  string(dir),
+		"mail": m.Key,
+	}).Info("Unlearn mail")
+
+	return m.UnlearnStore(NewBoltStore(db), dir)
+}
+
+// UnlearnStore works like Unlearn, but removes the mail from any Store.
+func (m *Mail) UnlearnStore(s Store, dir Maildir) (err error) {
+
+	err = m.Load(dir)
+	if err != nil {
+		return err
 	}
+	defer m.Unload(dir)
 
-	// Update the statistics counter
-	err = m.learnStatistics(db)
+	t, err := storeTokenizer(s)
 	if err != nil {
 		return err
 	}
 
-	err = m.Unload(dir)
+	list, err := m.cleanWordlist(t)
+	if err != nil {
+		return err
+	}
+	words := uniqueWords(list)
+
+	return s.Update(func(tx Tx) error {
+		c, err := countingOf(tx)
+		if err != nil {
+			return err
+		}
+		if !c.identities() {
+			return errCannotUnlearn
+		}
 
-	return err
+		for _, prefix := range m.learnedPrefixes(tx) {
+			n := make(map[string]uint64)
+			for _, k := range m.learnKeys() {
+				class := string(tx.Get(prefix+"Learned", k))
+				if class == "" {
+					continue
+				}
+				n[class]++
 
+				err = tx.Delete(prefix+"Learned", k)
+				if err != nil {
+					return err
+				}
+			}
+
+			for class, moved := range n {
+				err = uncount(tx, prefix, class, words, moved)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
 }
@@ -3,9 +3,9 @@ package sisyphus_test
 import (
 	"os"
 
-	"github.com/boltdb/bolt"
 	. "github.com/carlostrub/sisyphus"
 	"github.com/retailnext/hllpp"
+	bolt "go.etcd.io/bbolt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
@@ -22,12 +23,19 @@ import (
 // Maildir represents the address to a Maildir directory
 type Maildir string
 
-// Mail includes the key of a mail in Maildir
+// Mail includes the key of a mail in Maildir. Folder names the Maildir++
+// folder of a mail outside the inbox and the Junk folder. Received is the
+// time the mail arrived, if it cannot be told from its key.
 type Mail struct {
 	Key           string
+	Folder        string
+	Received      time.Time
 	Subject, Body *string
+	Flags         string
 	Junk, New     bool
 	DryRun        bool
+	Shared        *Shared
+	Weight        int
 }
 
 // CreateDirs creates all the required dirs -- if not already there.
@@ -39,7 +47,7 @@ func (d Maildir) CreateDirs() error {
 		"dir": dir,
 	}).Info("Create missing directories")
 
-	err := os.MkdirAll(filepath.Join(dir, ".Junk", "cur"), 0700)
+	err := os.MkdirAll(filepath.Join(dir, layout.Junk, "cur"), 0700)
 	if err != nil {
 		return err
 	}
@@ -52,6 +60,75 @@ func (d Maildir) CreateDirs() error {
 	return err
 }
 
+// FolderCounts returns the number of mails in the inbox, the Junk folder and
+// the other folders learned from
+func (d Maildir) FolderCounts() (counts map[string]int, err error) {
+	counts = make(map[string]int)
+
+	folders, err := d.learnFolders()
+	if err != nil {
+		return counts, err
+	}
+
+	for _, folder := range folders {
+		name := folder.name
+		if name == "" {
+			name = "INBOX"
+		}
+		for _, sub := range []string{"new", "cur"} {
+			f, err := os.Open(filepath.Join(string(d), folder.name, sub))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return counts, err
+			}
+			names, err := f.Readdirnames(0)
+			f.Close()
+			if err != nil {
+				return counts, err
+			}
+
+			for _, n := range names {
+				if n[0] != '.' {
+					counts[name]++
+				}
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+// readCur returns the mails in the cur directory of a folder, with the info
+// flags found in their file names.
+func readCur(folder string) (m []*Mail, err error) {
+	f, err := os.Open(filepath.Join(folder, "cur"))
+	if err != nil {
+		return m, err
+	}
+	names, err := f.Readdirnames(0)
+	f.Close()
+	if err != nil {
+		return m, err
+	}
+
+	for _, n := range names {
+		if n[0] == '.' {
+			continue
+		}
+
+		name := strings.SplitN(n, ":", 2)
+		mail := &Mail{Key: name[0]}
+		if len(name) == 2 && strings.HasPrefix(name[1], "2,") {
+			mail.Flags = name[1][2:]
+		}
+		m = append(m, mail)
+	}
+
+	return m, nil
+}
+
 // Index loads all mail keys from the Maildir directory for processing.
 func (d Maildir) Index() (m []*Mail, err error) {
 
@@ -61,19 +138,23 @@ func (d Maildir) Index() (m []*Mail, err error) {
 		"dir": dir,
 	}).Info("Start indexing mails")
 
-	dirs := []string{dir, filepath.Join(dir, ".Junk")}
-	for _, val := range dirs {
-		j, err := maildir.Dir(val).Keys()
+	folders, err := d.learnFolders()
+	if err != nil {
+		return m, err
+	}
+
+	for _, f := range folders {
+		j, err := readCur(filepath.Join(dir, f.name))
 		if err != nil {
 			return m, err
 		}
 		for _, v := range j {
-			var new Mail
-			new.Key = v
-			if val == filepath.Join(dir, ".Junk") {
-				new.Junk = true
+			v.Junk = f.junk
+			// the Junk folder is found without a folder name
+			if f.name != layout.Junk {
+				v.Folder = f.name
 			}
-			m = append(m, &new)
+			m = append(m, v)
 		}
 	}
 
@@ -84,23 +165,34 @@ func (d Maildir) Index() (m []*Mail, err error) {
 	return m, nil
 }
 
-// Load reads a mail's subject and body
-func (m *Mail) Load(dir Maildir) (err error) {
-
-	var message *mail.Message
-
+// folder returns the directory within the Maildir the mail is stored in
+func (m *Mail) folder(dir Maildir) Maildir {
 	switch {
+	case m.Folder != "":
+		return Maildir(filepath.Join(string(dir), m.Folder))
 	case m.Junk:
-		dir = Maildir(filepath.Join(string(dir), ".Junk"))
+		return Maildir(filepath.Join(string(dir), layout.Junk))
 	case m.New:
-		dir = Maildir(filepath.Join(string(dir), "new"))
+		return Maildir(filepath.Join(string(dir), "new"))
 	}
 
-	message, err = maildir.Dir(dir).Message(m.Key)
+	return dir
+}
+
+// Load reads a mail's subject and body
+func (m *Mail) Load(dir Maildir) (err error) {
+
+	message, err := maildir.Dir(m.folder(dir)).Message(m.Key)
 	if err != nil {
 		return err
 	}
 
+	return m.parse(message)
+}
+
+// parse reads a mail's subject and body from a message
+func (m *Mail) parse(message *mail.Message) error {
+
 	// get Subject
 	if m.Subject != nil {
 		return errors.New("there is already a subject")
@@ -185,18 +277,15 @@ func (m *Mail) Clean() error {
 	return nil
 }
 
-// wordlist takes a string of space separated text and returns a list of unique
-// words in a space separated string
-func wordlist(s string) (l []string, err error) {
-	list := make(map[string]int)
-
+// tokens takes a string of space separated text and returns the words of the
+// text in the order they appear, up to the first 1000
+func tokens(s string) (clean []string, err error) {
 	raw := strings.Split(s, " ")
-	var clean []string
 
 	// use regexp compile for use in the loop that follows
 	regexMatcher, err := regexp.Compile("(^[a-z]+$)")
 	if err != nil {
-		return l, err
+		return clean, err
 	}
 
 	for _, w := range raw {
@@ -227,8 +316,21 @@ func wordlist(s string) (l []string, err error) {
 
 	// only the first 1000 words count
 	maxWords := int(math.Min(1000, float64(len(clean))))
-	for i := 0; i < maxWords; i++ {
-		w := clean[i]
+
+	return clean[:maxWords], nil
+}
+
+// wordlist takes a string of space separated text and returns a list of unique
+// words in a space separated string
+func wordlist(s string) (l []string, err error) {
+	list := make(map[string]int)
+
+	clean, err := tokens(s)
+	if err != nil {
+		return l, err
+	}
+
+	for _, w := range clean {
 		list[w]++
 	}
 
@@ -239,10 +341,8 @@ func wordlist(s string) (l []string, err error) {
 	return l, nil
 }
 
-// Wordlist prepares the mail for training
-func (m *Mail) Wordlist() (w []string, err error) {
-	var s string
-
+// text returns the subject and the body of the mail
+func (m *Mail) text() (s string) {
 	if m.Subject != nil {
 		s = s + " " + *m.Subject
 	}
@@ -251,21 +351,59 @@ func (m *Mail) Wordlist() (w []string, err error) {
 		s = s + " " + *m.Body
 	}
 
-	w, err = wordlist(s)
+	return s
+}
+
+// Wordlist prepares the mail for training
+func (m *Mail) Wordlist() (w []string, err error) {
+	return wordlist(m.text())
+}
+
+// tokenizer tells how a store splits mails into words: the features it
+// learns and the language its words are reduced in
+type tokenizer struct {
+	features string
+	language string
+}
+
+// storeTokenizer returns the tokenizer of a store. Stores not telling learn
+// single words as they are.
+func storeTokenizer(s Store) (t tokenizer, err error) {
+	err = s.View(func(tx Tx) error {
+		t.features = metaString(tx, "Features", FeatureWords)
+		t.language = metaString(tx, "Language", LanguageNone)
+		return nil
+	})
 
-	return w, err
+	return t, err
 }
 
-// cleanWordlist combines Clean and Wordlist in one internal function
-func (m *Mail) cleanWordlist() (w []string, err error) {
+// cleanWordlist combines Clean and Wordlist in one internal function. The
+// words are reduced to their stems in the language of the tokenizer, and
+// the pairs of words selected by its features follow them.
+func (m *Mail) cleanWordlist(t tokenizer) (w []string, err error) {
 	err = m.Clean()
 	if err != nil {
 		return w, err
 	}
 
-	w, err = m.Wordlist()
+	if t.features == FeatureWords && t.language == LanguageNone {
+		return m.Wordlist()
+	}
+
+	text := m.text()
+	words, err := tokens(text)
+	if err != nil {
+		return w, err
+	}
+
+	language := t.language
+	if language == LanguageAuto {
+		language = detectLanguage(text)
+	}
+	words = stemWords(words, language)
 
-	return w, err
+	return append(uniqueWords(words), phrases(words, t.features)...), nil
 }
 
 // LoadMails loads all mails from a given slice of Maildirs
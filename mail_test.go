@@ -51,47 +51,58 @@ var _ = Describe("Mail", func() {
 				[]*s.Mail{
 
 					{
-						Key:  "1488181583.M633084P4781.mail.carlostrub.ch,S=708375,W=720014",
-						Junk: true,
+						Key:   "1488181583.M633084P4781.mail.carlostrub.ch,S=708375,W=720014",
+						Flags: "a",
+						Junk:  true,
 					},
 					{
-						Key:  "1488226337.M327822P8269.mail.carlostrub.ch,S=3620,W=3730",
-						Junk: true,
+						Key:   "1488226337.M327822P8269.mail.carlostrub.ch,S=3620,W=3730",
+						Flags: "Sa",
+						Junk:  true,
 					},
 					{
-						Key:  "1488226337.M327824P8269.mail.carlostrub.ch,S=8044,W=8167",
-						Junk: true,
+						Key:   "1488226337.M327824P8269.mail.carlostrub.ch,S=8044,W=8167",
+						Flags: "Sa",
+						Junk:  true,
 					},
 					{
-						Key:  "1488226337.M327825P8269.mail.carlostrub.ch,S=802286,W=812785",
-						Junk: true,
+						Key:   "1488226337.M327825P8269.mail.carlostrub.ch,S=802286,W=812785",
+						Flags: "Sa",
+						Junk:  true,
 					},
 					{
-						Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161",
-						Junk: true,
+						Key:   "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161",
+						Flags: "Sa",
+						Junk:  true,
 					},
 					{
-						Key:  "1488228352.M339670P8269.mail.carlostrub.ch,S=12659,W=12782",
-						Junk: true,
+						Key:   "1488228352.M339670P8269.mail.carlostrub.ch,S=12659,W=12782",
+						Flags: "Sa",
+						Junk:  true,
 					},
 					{
-						Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+						Key:   "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+						Flags: "Sa",
 					},
 					{
-						Key:  "1504991721.M985788P1901.mail.carlostrub.ch,S=6474,W=6588",
-						Junk: true,
+						Key:   "1504991721.M985788P1901.mail.carlostrub.ch,S=6474,W=6588",
+						Flags: "S",
+						Junk:  true,
 					},
 					{
-						Key:  "1504991774.M467861P1924.mail.carlostrub.ch,S=6478,W=6592",
-						Junk: true,
+						Key:   "1504991774.M467861P1924.mail.carlostrub.ch,S=6478,W=6592",
+						Flags: "S",
+						Junk:  true,
 					},
 					{
-						Key:  "1505075914.M288773P9791.mail.carlostrub.ch,S=21241,W=21583",
-						Junk: true,
+						Key:   "1505075914.M288773P9791.mail.carlostrub.ch,S=21241,W=21583",
+						Flags: "S",
+						Junk:  true,
 					},
 					{
-						Key:  "1505392305.M710650P33881.mail.carlostrub.ch,S=6961,W=7064",
-						Junk: true,
+						Key:   "1505392305.M710650P33881.mail.carlostrub.ch,S=6961,W=7064",
+						Flags: "S",
+						Junk:  true,
 					},
 				}))
 		})
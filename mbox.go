@@ -0,0 +1,209 @@
+package sisyphus
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/mail"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// fromLine starts every message in an mbox file
+var fromLine = []byte("From ")
+
+// MboxReader reads the messages of an mbox file one by one.
+type MboxReader struct {
+	r     *bufio.Reader
+	from  []byte
+	blank bool
+}
+
+// NewMboxReader returns a reader for the messages of an mbox file.
+func NewMboxReader(r io.Reader) *MboxReader {
+	return &MboxReader{
+		r:     bufio.NewReader(r),
+		blank: true,
+	}
+}
+
+// Next returns the next message as stored in the mbox file, starting with
+// its From_ line. Within a message, only a From_ line after an empty line
+// starts the next one. Next returns io.EOF after the last message.
+func (r *MboxReader) Next() (raw []byte, err error) {
+	var buf bytes.Buffer
+	if r.from != nil {
+		buf.Write(r.from)
+		r.from = nil
+	}
+
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(line) > 0 {
+			start := (r.blank || buf.Len() == 0) && bytes.HasPrefix(line, fromLine)
+			r.blank = len(bytes.TrimRight(line, "\r\n")) == 0
+
+			switch {
+			case start && buf.Len() > 0:
+				r.from = line
+				return buf.Bytes(), nil
+			case start || buf.Len() > 0:
+				// text before the first From_ line is no message
+				buf.Write(line)
+			}
+		}
+
+		if err == io.EOF && buf.Len() > 0 {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// mboxMessage parses a message read from an mbox file. It drops the From_
+// line and unquotes lines escaped as >From.
+func mboxMessage(raw []byte) (*mail.Message, error) {
+	if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+		raw = raw[i+1:]
+	}
+
+	var buf bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	s.Buffer(make([]byte, 64*1024), len(raw)+1)
+	for s.Scan() {
+		line := s.Bytes()
+		if len(line) > 0 && line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), fromLine) {
+			line = line[1:]
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if s.Err() != nil {
+		return nil, s.Err()
+	}
+
+	return mail.ReadMessage(&buf)
+}
+
+// mboxMail returns the mail of a message read from an mbox file.
+func mboxMail(raw []byte) (m *Mail, header *mail.Header, err error) {
+	message, err := mboxMessage(raw)
+	if err != nil {
+		return m, header, err
+	}
+
+	m, err = corpusMail(message, raw)
+
+	return m, &message.Header, err
+}
+
+// LearnMbox learns all mails in an mbox file as good or junk, reporting its
+// progress if progress is not nil. It returns the number of mails learned.
+// Messages that cannot be parsed are skipped.
+func LearnMbox(db *bolt.DB, path string, junk bool, progress Progress) (n int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return n, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return n, err
+	}
+
+	var read int64
+	r := NewMboxReader(f)
+	for {
+		raw, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		read += int64(len(raw))
+
+		m, _, err := mboxMail(raw)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"mbox": path,
+			}).Warning("Cannot read mail")
+			continue
+		}
+		m.Junk = junk
+
+		err = m.learn(NewBoltStore(db), path)
+		if err != nil {
+			return n, err
+		}
+		n++
+
+		if progress != nil {
+			progress(n, float64(read)/float64(info.Size()))
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"mbox":  path,
+		"mails": n,
+		"junk":  junk,
+	}).Info("Mbox learned")
+
+	return n, nil
+}
+
+// ClassifyMbox classifies all mails of an mbox file like Classify does for
+// new mails in the Maildir, and writes them unchanged to the good or the junk
+// output. Messages that cannot be parsed are written to the good output. It
+// returns the number of mails written to each output.
+func (d Maildir) ClassifyMbox(db *bolt.DB, shared *Shared, in io.Reader, good, junk io.Writer) (nGood, nJunk int, err error) {
+
+	r := NewMboxReader(in)
+	for {
+		raw, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nGood, nJunk, err
+		}
+
+		isJunk := false
+		m, header, err := mboxMail(raw)
+		if err == nil {
+			m.Shared = shared
+			isJunk, _, _, err = m.judge(NewBoltStore(db), d, header)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Warning("Cannot classify mail")
+		}
+
+		out := good
+		if isJunk {
+			out = junk
+			nJunk++
+		} else {
+			nGood++
+		}
+
+		// keep the messages apart, even if the input lacks a final newline
+		for !bytes.HasSuffix(raw, []byte("\n\n")) {
+			raw = append(raw, '\n')
+		}
+		_, err = out.Write(raw)
+		if err != nil {
+			return nGood, nJunk, err
+		}
+	}
+
+	return nGood, nJunk, nil
+}
@@ -0,0 +1,107 @@
+package sisyphus_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mbox writes the given mail files into an mbox file
+func mbox(path string, files ...string) {
+	var buf bytes.Buffer
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		buf.WriteString("From sisyphus@example.com Thu Jan  1 00:00:00 2015\n")
+		buf.Write(raw)
+		buf.WriteString("\n")
+	}
+
+	err := ioutil.WriteFile(path, buf.Bytes(), 0600)
+	Ω(err).ShouldNot(HaveOccurred())
+}
+
+var _ = Describe("Mbox", func() {
+
+	Context("Read an mbox file", func() {
+		It("Splits messages at From_ lines after empty lines", func() {
+			r := NewMboxReader(strings.NewReader("junk before\nFrom a\nSubject: 1\n\nbody\nFrom here\n>From there\n\nFrom b\nSubject: 2\n\nbody"))
+
+			raw, err := r.Next()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(raw)).Should(Equal("From a\nSubject: 1\n\nbody\nFrom here\n>From there\n\n"))
+
+			raw, err = r.Next()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(raw)).Should(Equal("From b\nSubject: 2\n\nbody"))
+
+			_, err = r.Next()
+			Ω(err).Should(Equal(io.EOF))
+		})
+	})
+
+	Context("Learn and classify mbox files", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, err = ioutil.TempDir("", "sisyphus")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			mbox(filepath.Join(dir, "good.mbox"), "test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa")
+			mbox(filepath.Join(dir, "junk.mbox"), "test/Maildir/.Junk/cur/1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa")
+
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			os.RemoveAll(dir)
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Learns all mails of an mbox file", func() {
+			n, err := LearnMbox(dbs["test/Maildir"], filepath.Join(dir, "junk.mbox"), true, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(1))
+
+			s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.JunkMails).Should(Equal(uint64(1)))
+			Ω(s.GoodMails).Should(Equal(uint64(0)))
+		})
+
+		It("Splits an mbox file into good and junk mails", func() {
+			_, err = LearnMbox(dbs["test/Maildir"], filepath.Join(dir, "good.mbox"), false, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = LearnMbox(dbs["test/Maildir"], filepath.Join(dir, "junk.mbox"), true, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			mbox(filepath.Join(dir, "in.mbox"),
+				"test/Maildir/.Junk/cur/1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+				"test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa",
+			)
+			in, err := os.Open(filepath.Join(dir, "in.mbox"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer in.Close()
+
+			var good, junk bytes.Buffer
+			nGood, nJunk, err := Maildir("test/Maildir").ClassifyMbox(dbs["test/Maildir"], nil, in, &good, &junk)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(nGood).Should(Equal(1))
+			Ω(nJunk).Should(Equal(1))
+			Ω(good.String()).Should(ContainSubstring("danfe@FreeBSD.org"))
+			Ω(junk.String()).Should(ContainSubstring("eyehealth@felytial.us"))
+		})
+	})
+})
@@ -0,0 +1,201 @@
+package sisyphus
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errReadOnly is returned when writing in a read-only transaction
+var errReadOnly = errors.New("transaction is read-only")
+
+// memoryStore is a Store in memory. Buckets are kept flat by their paths.
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore returns an empty Store in memory, e.g. for tests or to
+// evaluate the classifier without touching a database. It counts like
+// databases created now, see SetCounting.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: map[string]map[string][]byte{
+			"Meta": {"Counter": []byte(counting.String())},
+		},
+	}
+}
+
+func (s *memoryStore) View(fn func(Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{
+		store:   s,
+		changed: make(map[string]map[string][]byte),
+	}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	// a changed bucket that is nil has been deleted
+	for name, b := range tx.changed {
+		if b == nil {
+			delete(s.buckets, name)
+		} else {
+			s.buckets[name] = b
+		}
+	}
+
+	return nil
+}
+
+// memoryTx is a Tx of a memoryStore. Writable transactions copy the buckets
+// they change, so the store stays untouched until the transaction succeeds.
+type memoryTx struct {
+	store   *memoryStore
+	changed map[string]map[string][]byte
+}
+
+// bucket returns a bucket as seen by the transaction
+func (t *memoryTx) bucket(name string) map[string][]byte {
+	if b, ok := t.changed[name]; ok {
+		return b
+	}
+
+	return t.store.buckets[name]
+}
+
+// writable returns a bucket the transaction may change
+func (t *memoryTx) writable(name string) (map[string][]byte, error) {
+	if t.changed == nil {
+		return nil, errReadOnly
+	}
+
+	b, ok := t.changed[name]
+	if b != nil {
+		return b, nil
+	}
+
+	// a bucket deleted earlier in the transaction starts out empty
+	b = make(map[string][]byte)
+	if !ok {
+		for k, v := range t.store.buckets[name] {
+			b[k] = v
+		}
+	}
+	t.changed[name] = b
+
+	return b, nil
+}
+
+func (t *memoryTx) Get(bucket, key string) []byte {
+	return t.bucket(bucket)[key]
+}
+
+func (t *memoryTx) Put(bucket, key string, value []byte) error {
+	b, err := t.writable(bucket)
+	if err != nil {
+		return err
+	}
+
+	b[key] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (t *memoryTx) Delete(bucket, key string) error {
+	if t.bucket(bucket) == nil {
+		return nil
+	}
+
+	b, err := t.writable(bucket)
+	if err != nil {
+		return err
+	}
+
+	delete(b, key)
+
+	return nil
+}
+
+func (t *memoryTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b := t.bucket(bucket)
+
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err := fn(k, b[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTx) Len(bucket string) int {
+	return len(t.bucket(bucket))
+}
+
+// names returns the paths of all buckets seen by the transaction
+func (t *memoryTx) names() (names []string) {
+	for name := range t.store.buckets {
+		if _, ok := t.changed[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name, b := range t.changed {
+		if b != nil {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func (t *memoryTx) Buckets(bucket string) (names []string) {
+	prefix := bucket + "/"
+	seen := make(map[string]bool)
+
+	for _, name := range t.names() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		nested := strings.SplitN(name[len(prefix):], "/", 2)[0]
+		if !seen[nested] {
+			seen[nested] = true
+			names = append(names, nested)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (t *memoryTx) DeleteBucket(bucket string) error {
+	if t.changed == nil {
+		return errReadOnly
+	}
+
+	for _, name := range t.names() {
+		if name == bucket || strings.HasPrefix(name, bucket+"/") {
+			t.changed[name] = nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package sisyphus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/retailnext/hllpp"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Shared is a site-wide database consulted in addition to the database of a
+// Maildir when classifying, so new accounts get good filtering from what
+// other accounts have learned. The counts of the shared database are scaled
+// by Weight before they are added to the counts of the Maildir.
+type Shared struct {
+	DB     *bolt.DB
+	Weight float64
+}
+
+// Check returns an error if the shared database splits mails into other
+// words than db, so the words of a mail classified with db would not be
+// found in the shared database.
+func (s Shared) Check(db *bolt.DB) error {
+	meta, err := ReadMeta(db)
+	if err != nil {
+		return err
+	}
+	sharedMeta, err := ReadMeta(s.DB)
+	if err != nil {
+		return err
+	}
+
+	return sameWords(meta, sharedMeta)
+}
+
+// sameWords returns an error if two databases split mails into other words
+func sameWords(a, b Meta) error {
+	if a.Tokenizer != b.Tokenizer {
+		return errors.New("databases were learned with different tokenizers")
+	}
+	if a.Features != b.Features {
+		return errors.New("databases learn different features")
+	}
+	if a.Language != b.Language {
+		return errors.New("databases reduce words in different languages")
+	}
+
+	return nil
+}
+
+// mergeSketch returns the union of two marshalled HyperLogLog sketches.
+func mergeSketch(dstRaw, srcRaw []byte) ([]byte, error) {
+	src, err := hllpp.Unmarshal(srcRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(dstRaw) == 0 {
+		return src.Marshal(), nil
+	}
+
+	dst, err := hllpp.Unmarshal(dstRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dst.Merge(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return dst.Marshal(), nil
+}
+
+// Merge adds the learned model of src to dst. With HyperLogLog sketches,
+// every word is a sketch of the keys of the mails it appeared in, so merging
+// is the union of both sketches: the result is the same as if dst had
+// learned all mails of src, and mails known to both are not counted twice.
+// Other counting models add the counts, so they refuse databases that have
+// learned the same mails. Both databases must split mails into the same
+// words and count them the same way. The last time dst learned is moved on
+// to that of src, if src learned later.
+func Merge(dst, src *bolt.DB) error {
+
+	dstMeta, err := ReadMeta(dst)
+	if err != nil {
+		return err
+	}
+	srcMeta, err := ReadMeta(src)
+	if err != nil {
+		return err
+	}
+	err = sameWords(dstMeta, srcMeta)
+	if err != nil {
+		return err
+	}
+	if dstMeta.TokenHash != srcMeta.TokenHash {
+		return errors.New("databases hash their words differently")
+	}
+	if dstMeta.Counter != srcMeta.Counter {
+		return errors.New("databases count their words differently")
+	}
+
+	return NewBoltStore(src).View(func(stx Tx) error {
+		return NewBoltStore(dst).Update(func(dtx Tx) error {
+			counting, err := countingOf(dtx)
+			if err != nil {
+				return err
+			}
+
+			for _, path := range learnedPaths(stx) {
+				err := stx.ForEach(path, func(k string, v []byte) error {
+					if dtx.Get(path, k) != nil && counting.identities() {
+						return fmt.Errorf("mail %s learned by both databases", k)
+					}
+					return dtx.Put(path, k, v)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, path := range modelPaths(stx) {
+				c, err := counterOf(dtx, path)
+				if err != nil {
+					return err
+				}
+
+				err = stx.ForEach(path, func(k string, v []byte) error {
+					merged, err := c.merge(dtx.Get(path, k), v)
+					if err != nil {
+						return err
+					}
+
+					return dtx.Put(path, k, merged)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if !srcMeta.LastLearned.After(dstMeta.LastLearned) {
+				return nil
+			}
+
+			return dtx.Put("Meta", "LastLearned", []byte(srcMeta.LastLearned.UTC().Format(time.RFC3339)))
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Merge", func() {
+
+	Context("Merge databases", func() {
+		BeforeEach(func() {
+			err = LoadMaildirs([]Maildir{"test/Maildir2"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = os.RemoveAll("test/Maildir2")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Classifies with the merged model", func() {
+			err = Merge(dbs["test/Maildir2"], dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			answer, prob, err := Junk(dbs["test/Maildir2"], []string{"london"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(prob).Should(Equal(1.0))
+			Ω(answer).Should(BeTrue())
+		})
+
+		It("Takes over the last time the source learned", func() {
+			err = Merge(dbs["test/Maildir2"], dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			src, err := ReadMeta(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			dst, err := ReadMeta(dbs["test/Maildir2"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(src.LastLearned.IsZero()).Should(BeFalse())
+			Ω(dst.LastLearned).Should(Equal(src.LastLearned))
+		})
+
+		It("Does not count mails twice", func() {
+			err = Merge(dbs["test/Maildir2"], dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			err = Merge(dbs["test/Maildir2"], dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			gTotal, jTotal, _, _ := Info(dbs["test/Maildir2"])
+			Ω(gTotal).Should(Equal(uint64(1)))
+			Ω(jTotal).Should(Equal(uint64(1)))
+		})
+
+		It("Consults a shared database when classifying", func() {
+			shared := Shared{
+				DB:     dbs["test/Maildir"],
+				Weight: 1,
+			}
+			err = shared.Check(dbs["test/Maildir2"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			answer, prob, err := JunkShared(dbs["test/Maildir2"], shared, []string{"london"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(prob).Should(Equal(1.0))
+			Ω(answer).Should(BeTrue())
+		})
+
+		It("Refuses databases learning other features", func() {
+			CloseDatabases(dbs)
+			err = SetFeatures("test/Maildir2", FeatureBigrams)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer SetFeatures("test/Maildir2", "")
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir", "test/Maildir2"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = Merge(dbs["test/Maildir2"], dbs["test/Maildir"])
+			Ω(err).Should(HaveOccurred())
+
+			err = Shared{DB: dbs["test/Maildir"], Weight: 1}.Check(dbs["test/Maildir2"])
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package sisyphus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	mailsClassified = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sisyphus_mails_classified_total",
+		Help: "Number of mails classified, by maildir and verdict.",
+	}, []string{"maildir", "verdict"})
+
+	classifyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sisyphus_classification_duration_seconds",
+		Help:    "Time needed to classify a mail, by maildir.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"maildir"})
+
+	mailsLearned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sisyphus_mails_learned_total",
+		Help: "Number of mails learned, by maildir and class.",
+	}, []string{"maildir", "class"})
+
+	tokensPruned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sisyphus_tokens_pruned_total",
+		Help: "Number of words dropped from the database, by maildir and reason.",
+	}, []string{"maildir", "reason"})
+
+	// LearnCycleDuration observes how long a learning period over all
+	// maildirs takes.
+	LearnCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sisyphus_learn_cycle_duration_seconds",
+		Help:    "Time needed to learn all mails of all maildirs.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	})
+
+	// WatcherErrors counts the problems reported by the directory watcher.
+	WatcherErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sisyphus_watcher_errors_total",
+		Help: "Number of problems reported by the directory watcher.",
+	})
+
+	// QueueLength is the number of new mails waiting to be classified.
+	QueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sisyphus_classification_queue_length",
+		Help: "Number of new mails waiting to be classified.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		mailsClassified,
+		classifyDuration,
+		mailsLearned,
+		tokensPruned,
+		LearnCycleDuration,
+		WatcherErrors,
+		QueueLength,
+	)
+}
+
+// RegisterMetrics exports the size of the Maildir's database as a metric.
+func (d Maildir) RegisterMetrics(h *Handle) error {
+	return prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "sisyphus_database_size_bytes",
+		Help:        "Size of the database, by maildir.",
+		ConstLabels: prometheus.Labels{"maildir": string(d)},
+	}, func() float64 {
+		var size int64
+		_ = h.Use(func(db *bolt.DB) error {
+			return db.View(func(tx *bolt.Tx) error {
+				size = tx.Size()
+				return nil
+			})
+		})
+		return float64(size)
+	}))
+}
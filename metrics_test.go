@@ -0,0 +1,128 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+
+	// sample returns the value of a counter or gauge and the number of
+	// observations of a histogram with the given labels
+	sample := func(name string, labels map[string]string) (value float64, count uint64) {
+		families, err := prometheus.DefaultGatherer.Gather()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		for _, f := range families {
+			if f.GetName() != name {
+				continue
+			}
+		metrics:
+			for _, m := range f.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if labels[l.GetName()] != l.GetValue() {
+						continue metrics
+					}
+				}
+				return m.GetCounter().GetValue() + m.GetGauge().GetValue(), m.GetHistogram().GetSampleCount()
+			}
+		}
+
+		return 0, 0
+	}
+
+	learned := func(class string) float64 {
+		v, _ := sample("sisyphus_mails_learned_total", map[string]string{
+			"maildir": "test/Maildir",
+			"class":   class,
+		})
+		return v
+	}
+
+	Context("Prometheus metrics", func() {
+		BeforeEach(func() {
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			c, _ := ParseCounting("hll")
+			SetCounting(c)
+			CloseDatabases(dbs)
+
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Counts learned mails and exports the database size", func() {
+			before := learned("junk")
+
+			m = &Mail{
+				Key:  "1488226337.M327822P8269.mail.carlostrub.ch,S=3620,W=3730",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(learned("junk")).Should(Equal(before + 1))
+
+			err = Maildir("test/Maildir").RegisterMetrics(NewHandle(dbs["test/Maildir"]))
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			size, _ := sample("sisyphus_database_size_bytes", map[string]string{
+				"maildir": "test/Maildir",
+			})
+			Ω(size).Should(BeNumerically(">", 0))
+		})
+
+		It("Counts mails learned before only once if their keys are kept", func() {
+			CloseDatabases(dbs)
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			c, err := ParseCounting("exact")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = SetCounting(c)
+			Ω(err).ShouldNot(HaveOccurred())
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			before := learned("good")
+			for i := 0; i < 2; i++ {
+				m = &Mail{
+					Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+				}
+				err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(learned("good")).Should(Equal(before + 1))
+		})
+
+		It("Observes the time needed to classify a mail", func() {
+			raw, err := ioutil.ReadFile("test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M3P3.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove("test/Maildir/new/1500000000.M3P3.test")
+
+			labels := map[string]string{"maildir": "test/Maildir"}
+			_, before := sample("sisyphus_classification_duration_seconds", labels)
+
+			m = &Mail{
+				Key:    "1500000000.M3P3.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, after := sample("sisyphus_classification_duration_seconds", labels)
+			Ω(after).Should(Equal(before + 1))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+package sisyphus
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Pruning bounds the number of words kept in a database. Words learned from
+// a single mail are dropped once no month younger than HapaxAge has learned
+// them; as only the periods tell when a word was learned, this requires a
+// Decay, see SetDecay. Of the remaining words, only the Keep most informative are kept. If
+// the data of the database takes more than MaxSize bytes, the number of words
+// kept is reduced in proportion. Zero values disable the respective rule.
+type Pruning struct {
+	HapaxAge time.Duration
+	Keep     int
+	MaxSize  int64
+}
+
+// PruneReport tells how many words were dropped for having been learned from
+// a single mail long ago, and for being less informative than the words kept.
+type PruneReport struct {
+	Hapaxes       int `json:"hapaxes"`
+	Uninformative int `json:"uninformative"`
+}
+
+// informativeness tells how strongly a word points to one class: how far its
+// probability of being junk lies from one half, shrunk towards one half for
+// words learned from few mails.
+func informativeness(good, junk uint64) float64 {
+	n := float64(good + junk)
+	if n == 0 {
+		return 0
+	}
+
+	return math.Abs(float64(junk)/n-0.5) * n / (n + 1)
+}
+
+// recentWords returns the words learned in periods ending after the given
+// time.
+func recentWords(tx Tx, after time.Time) (words map[string]bool, err error) {
+	words = make(map[string]bool)
+	for _, p := range periods(tx) {
+		end, err := periodEnd(p)
+		if err != nil {
+			return words, err
+		}
+		if !end.After(after) {
+			continue
+		}
+
+		for _, class := range []string{"Good", "Junk"} {
+			err = tx.ForEach(periodPrefix(p)+"Wordlists/"+class, func(k string, _ []byte) error {
+				words[k] = true
+				return nil
+			})
+			if err != nil {
+				return words, err
+			}
+		}
+	}
+
+	return words, nil
+}
+
+// dropWord removes a word from all word lists, all-time and per period
+func dropWord(tx Tx, word string) error {
+	for _, path := range modelPaths(tx) {
+		if !strings.Contains(path, "Wordlists/") {
+			continue
+		}
+
+		err := tx.Delete(path, word)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// usedSize returns the number of bytes of the database file not free
+func usedSize(db *bolt.DB) (used int64, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		used = tx.Size() - int64(db.Stats().FreeAlloc)
+		return nil
+	})
+
+	return used, err
+}
+
+// PruneTokens drops words from the database of the Maildir as configured by
+// p, measuring the age of hapaxes from now. The counts of mails learned are
+// kept, so the dropped words are simply unknown to classification. Mails
+// learned before are not learned again, so the words dropped only come back
+// if new mails bring them. Count-min sketches have a fixed size and are not
+// pruned. The file only shrinks once the database is compacted.
+func (d Maildir) PruneTokens(db *bolt.DB, p Pruning, now time.Time) (r PruneReport, err error) {
+
+	used, err := usedSize(db)
+	if err != nil {
+		return r, err
+	}
+
+	err = NewBoltStore(db).Update(func(tx Tx) error {
+		c, err := countingOf(tx)
+		if err != nil || c.Model == CountMin {
+			return err
+		}
+
+		good, err := wordCounts(tx, "Good")
+		if err != nil {
+			return err
+		}
+		junk, err := wordCounts(tx, "Junk")
+		if err != nil {
+			return err
+		}
+
+		words := make(map[string]bool)
+		for w := range good {
+			words[w] = true
+		}
+		for w := range junk {
+			words[w] = true
+		}
+
+		drop := func(w string, n *int) error {
+			*n++
+			return dropWord(tx, w)
+		}
+
+		if p.HapaxAge > 0 && decay.periodic() {
+			recent, err := recentWords(tx, now.Add(-p.HapaxAge))
+			if err != nil {
+				return err
+			}
+
+			for w := range words {
+				if good[w]+junk[w] > 1 || recent[w] {
+					continue
+				}
+
+				err = drop(w, &r.Hapaxes)
+				if err != nil {
+					return err
+				}
+				delete(words, w)
+			}
+		}
+
+		keep, limited := p.Keep, p.Keep > 0
+		if p.MaxSize > 0 && used > p.MaxSize {
+			bySize := int(float64(len(words)) * float64(p.MaxSize) / float64(used))
+			if !limited || bySize < keep {
+				keep, limited = bySize, true
+			}
+		}
+
+		if limited && len(words) > keep {
+			ranked := make([]string, 0, len(words))
+			for w := range words {
+				ranked = append(ranked, w)
+			}
+
+			// most informative first, more evidence wins a tie
+			sort.Slice(ranked, func(i, j int) bool {
+				a, b := ranked[i], ranked[j]
+				ia, ib := informativeness(good[a], junk[a]), informativeness(good[b], junk[b])
+				if ia != ib {
+					return ia > ib
+				}
+				if good[a]+junk[a] != good[b]+junk[b] {
+					return good[a]+junk[a] > good[b]+junk[b]
+				}
+				return a < b
+			})
+
+			for _, w := range ranked[keep:] {
+				err = drop(w, &r.Uninformative)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if r.Hapaxes+r.Uninformative == 0 {
+			return nil
+		}
+
+		pruned, err := strconv.Atoi(string(tx.Get("Meta", "PrunedTokens")))
+		if err != nil {
+			pruned = 0
+		}
+
+		return tx.Put("Meta", "PrunedTokens", []byte(strconv.Itoa(pruned+r.Hapaxes+r.Uninformative)))
+	})
+	if err != nil {
+		return r, err
+	}
+
+	tokensPruned.WithLabelValues(string(d), "hapax").Add(float64(r.Hapaxes))
+	tokensPruned.WithLabelValues(string(d), "uninformative").Add(float64(r.Uninformative))
+
+	log.WithFields(log.Fields{
+		"maildir":       string(d),
+		"hapaxes":       r.Hapaxes,
+		"uninformative": r.Uninformative,
+	}).Info("Words pruned")
+
+	return r, nil
+}
@@ -0,0 +1,116 @@
+package sisyphus_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prune tokens", func() {
+
+	var gWords, jWords uint64
+
+	BeforeEach(func() {
+		// the age of hapaxes is told by the periods
+		err = SetDecay(Decay{HalfLife: 30 * 24 * time.Hour})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		for _, m := range []*Mail{
+			{Key: "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa", Junk: true},
+			{Key: "1488226337.M327822P8269.mail.carlostrub.ch,S=3620,W=3730:2,Sa", Junk: true},
+			{Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119"},
+		} {
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+
+		_, _, gWords, jWords = Info(dbs["test/Maildir"])
+	})
+	AfterEach(func() {
+		err = SetDecay(Decay{})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Keeps recent hapaxes", func() {
+		r, err := Maildir("test/Maildir").PruneTokens(dbs["test/Maildir"], Pruning{HapaxAge: 24 * time.Hour}, time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(r).Should(Equal(PruneReport{}))
+	})
+
+	It("Drops old hapaxes", func() {
+		r, err := Maildir("test/Maildir").PruneTokens(dbs["test/Maildir"], Pruning{HapaxAge: 24 * time.Hour}, time.Now())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(r.Hapaxes).Should(BeNumerically(">", 0))
+		Ω(r.Uninformative).Should(BeZero())
+
+		gTotal, jTotal, g, j := Info(dbs["test/Maildir"])
+		Ω(gTotal).Should(Equal(uint64(1)))
+		Ω(jTotal).Should(Equal(uint64(2)))
+		Ω(g + j).Should(BeNumerically("<", gWords+jWords))
+
+		s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(s.PrunedWords).Should(Equal(r.Hapaxes))
+	})
+
+	It("Keeps dropped words out when mails are learned again", func() {
+		r, err := Maildir("test/Maildir").PruneTokens(dbs["test/Maildir"], Pruning{HapaxAge: 24 * time.Hour}, time.Now())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(r.Hapaxes).Should(BeNumerically(">", 0))
+		_, _, g, j := Info(dbs["test/Maildir"])
+
+		// the next learning period learns all mails again
+		m = &Mail{Key: "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa", Junk: true}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, _, gAgain, jAgain := Info(dbs["test/Maildir"])
+		Ω(gAgain + jAgain).Should(Equal(g + j))
+
+		again, err := Maildir("test/Maildir").PruneTokens(dbs["test/Maildir"], Pruning{HapaxAge: 24 * time.Hour}, time.Now())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(again).Should(Equal(PruneReport{}))
+
+		s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(s.PrunedWords).Should(Equal(r.Hapaxes))
+	})
+
+	It("Keeps the most informative words", func() {
+		r, err := Maildir("test/Maildir").PruneTokens(dbs["test/Maildir"], Pruning{Keep: 10}, time.Now())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(r.Hapaxes).Should(BeZero())
+		Ω(r.Uninformative).Should(BeNumerically(">", 0))
+
+		s, err := Maildir("test/Maildir").Stats(dbs["test/Maildir"])
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(s.GoodWords + s.JunkWords).Should(BeNumerically("<=", 20))
+
+		// words found in both junk mails say most
+		Ω(s.TopJunk[0].Junk).Should(Equal(uint64(2)))
+	})
+
+	It("Drops words until the data fits", func() {
+		r, err := Maildir("test/Maildir").PruneTokens(dbs["test/Maildir"], Pruning{MaxSize: 1}, time.Now())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(r.Uninformative).Should(BeNumerically(">", 0))
+
+		_, _, g, j := Info(dbs["test/Maildir"])
+		Ω(g + j).Should(BeZero())
+	})
+})
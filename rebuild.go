@@ -0,0 +1,143 @@
+package sisyphus
+
+import (
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// rebuildFile is the name of the database a Maildir is retrained into
+const rebuildFile = "sisyphus.db.rebuild"
+
+// keptBuckets are carried over from the replaced database when a rebuilt
+// one is swapped in, as they cannot be learned from the Maildir.
+var keptBuckets = []string{"Scores", "History", "Rescued", "Allowlist"}
+
+// Rebuild trains a new database from scratch with the mails of the Maildir,
+// learning them with the given flag policies. The live database is not
+// touched, so this is safe while the daemon is running; SwapRebuild puts the
+// new database in place afterwards. The new database counts the way set by
+// SetCounting, so rebuilding also switches the counting model. It returns the
+// number of mails learned.
+func (d Maildir) Rebuild(policies FlagPolicies, progress Progress) (n int, err error) {
+
+	path := filepath.Join(string(d), rebuildFile)
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return n, err
+	}
+
+	db, err := OpenDatabase(path)
+	if err != nil {
+		return n, err
+	}
+	defer db.Close()
+
+	err = setTokenizer(db, dbPath(string(d)))
+	if err != nil {
+		return n, err
+	}
+
+	mails, err := d.Index()
+	if err != nil {
+		return n, err
+	}
+
+	for i, m := range mails {
+		if !policies.Apply(m) {
+			continue
+		}
+
+		err = m.Learn(db, d)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"mail": m.Key,
+			}).Warning("Cannot learn mail")
+			continue
+		}
+		n++
+
+		if progress != nil {
+			progress(n, float64(i+1)/float64(len(mails)))
+		}
+	}
+
+	return n, nil
+}
+
+// copyBuckets replaces the given buckets of dst by those of src
+func copyBuckets(dst, src *bolt.DB, buckets []string) error {
+	return NewBoltStore(src).View(func(stx Tx) error {
+		return NewBoltStore(dst).Update(func(dtx Tx) error {
+			for _, b := range buckets {
+				err := dtx.DeleteBucket(b)
+				if err != nil {
+					return err
+				}
+
+				err = stx.ForEach(b, func(k string, v []byte) error {
+					return dtx.Put(b, k, v)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+// Replace swaps the database at src in for the database of the Handle. The
+// scores, history, rescued mails and allowed senders are carried over, and
+// the replaced database is kept with the suffix .replaced. Users of the
+// Handle wait until the database is replaced, so no change gets lost.
+func (h *Handle) Replace(src string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := VerifyBackup(src)
+	if err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(src, 0600, nil)
+	if err != nil {
+		return err
+	}
+	err = copyBuckets(db, h.db, keptBuckets)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	path := h.db.Path()
+	os.Remove(path + ".replaced")
+	err = os.Link(path, path+".replaced")
+	if err != nil {
+		return err
+	}
+
+	err = h.swap(src)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"db": path,
+	}).Info("Database replaced")
+
+	return nil
+}
+
+// SwapRebuild replaces the database of the Maildir, held by the Handle, by
+// the one trained with Rebuild.
+func (d Maildir) SwapRebuild(h *Handle) error {
+	return h.Replace(filepath.Join(string(d), rebuildFile))
+}
@@ -0,0 +1,130 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rebuild", func() {
+
+	var h *Handle
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+		h = NewHandle(dbs["test/Maildir"])
+
+		// a junk mail wrongly learned as good pollutes the model
+		m = &Mail{
+			Key:    "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+			Folder: ".Junk",
+		}
+		err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = dbs["test/Maildir"].Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("Allowlist")).Put([]byte("friend@example.com"), []byte("2017-02-27T00:00:00Z"))
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		n, err := Maildir("test/Maildir").Rebuild(FlagPolicies{}, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(n).Should(Equal(11))
+	})
+	AfterEach(func() {
+		h.Close()
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+		os.Remove("test/Maildir/sisyphus.db.replaced")
+		os.Remove("test/Maildir/sisyphus.db.rebuild")
+	})
+
+	// swapped checks that the rebuilt database is in use
+	swapped := func() {
+		err = h.Use(func(db *bolt.DB) error {
+			gTotal, jTotal, _, _ := Info(db)
+			Ω(gTotal).Should(Equal(uint64(1)))
+			Ω(jTotal).Should(Equal(uint64(10)))
+
+			senders, err := Allowlist(db)
+			Ω(senders).Should(Equal([]string{"friend@example.com"}))
+
+			return err
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = os.Stat("test/Maildir/sisyphus.db.replaced")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = os.Stat("test/Maildir/sisyphus.db.rebuild")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	}
+
+	It("Trains a new database without touching the live one", func() {
+		gTotal, jTotal, _, _ := Info(dbs["test/Maildir"])
+		Ω(gTotal).Should(Equal(uint64(1)))
+		Ω(jTotal).Should(Equal(uint64(0)))
+	})
+
+	It("Swaps in the rebuilt database", func() {
+		err = Maildir("test/Maildir").SwapRebuild(h)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		swapped()
+	})
+
+	It("Keeps the database in use if the replacement cannot be opened", func() {
+		c, err := ParseCounting("cms")
+		Ω(err).ShouldNot(HaveOccurred())
+		err = SetCounting(c)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer SetCounting(Counting{Model: CountHLL, Precision: 14})
+
+		src := "test/Maildir/sisyphus.db.cms"
+		defer os.Remove(src)
+		db, err := OpenDatabase(src)
+		Ω(err).ShouldNot(HaveOccurred())
+		m = &Mail{Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119"}
+		err = m.Learn(db, "test/Maildir")
+		Ω(err).ShouldNot(HaveOccurred())
+		db.Close()
+
+		// the words of a count-min sketch cannot be hashed
+		err = SetTokenSecret([]byte("a secret of thirty-two bytes...."))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer SetTokenSecret(nil)
+
+		err = h.Replace(src)
+		Ω(err).Should(HaveOccurred())
+
+		err = h.Use(func(db *bolt.DB) error {
+			gTotal, jTotal, _, _ := Info(db)
+			Ω(gTotal).Should(Equal(uint64(1)))
+			Ω(jTotal).Should(Equal(uint64(0)))
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = os.Stat("test/Maildir/sisyphus.db.swap")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	It("Swaps in the rebuilt database of a running daemon", func() {
+		l, err := Maildir("test/Maildir").Serve(h)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer l.Close()
+
+		err = Maildir("test/Maildir").LiveSwapRebuild()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		swapped()
+	})
+})
@@ -0,0 +1,156 @@
+package sisyphus
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/carlostrub/maildir"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RescueRequest selects mails to be rescued from the Junk folder. Key selects
+// a single mail. Otherwise, all mails that were moved to the Junk folder by
+// classification and match Filter are selected, but only the Last most
+// recent ones if Last is positive. KeepFlags moves mails to cur instead of
+// new, keeping their Maildir flags.
+type RescueRequest struct {
+	Key       string
+	Filter    HistoryFilter
+	Last      int
+	KeepFlags bool
+}
+
+// movedToJunk returns the keys of the mails selected by the request, most
+// recent first. Mails rescued before are skipped.
+func movedToJunk(db *bolt.DB, r RescueRequest) (keys []string, err error) {
+	if r.Key != "" {
+		return []string{r.Key}, nil
+	}
+
+	entries, err := History(db, r.Filter)
+	if err != nil {
+		return keys, err
+	}
+
+	// the history is in chronological order, so the latest action on a
+	// mail wins
+	action := make(map[string]string)
+	for _, e := range entries {
+		action[e.Key] = e.Action
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		key := entries[i].Key
+		if action[key] != ActionMoved {
+			continue
+		}
+		delete(action, key)
+
+		keys = append(keys, key)
+		if r.Last > 0 && len(keys) == r.Last {
+			break
+		}
+	}
+
+	return keys, nil
+}
+
+// rescue moves a single mail from the Junk folder back to the inbox, learns
+// it as good and records the correction in the history.
+func (d Maildir) rescue(db *bolt.DB, key string, keepFlags bool) error {
+	junk := filepath.Join(string(d), layout.Junk)
+
+	path, err := maildir.Dir(junk).Filename(key)
+	if err != nil {
+		return err
+	}
+
+	header, err := maildir.Dir(junk).Header(key)
+	if err != nil {
+		return err
+	}
+
+	m := &Mail{Key: key}
+	entry := m.newHistoryEntry(header, math.NaN())
+	entry.Action = ActionRescued
+
+	// strip the info part of the file name in new, ensure one in cur
+	name := strings.SplitN(filepath.Base(path), ":", 2)
+	dest := filepath.Join(string(d), "new", name[0])
+	m.New = true
+	if keepFlags {
+		info := "2,"
+		if len(name) == 2 {
+			info = name[1]
+		}
+		dest = filepath.Join(string(d), "cur", name[0]+":"+info)
+		m.New = false
+	}
+
+	// remember the rescue first, so the mail is not classified again once
+	// it shows up in new
+	m.Key = name[0]
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("Rescued")).Put([]byte(m.Key), historyKey(entry.Time))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(path, dest)
+	if err != nil {
+		return err
+	}
+
+	err = m.Learn(db, d)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"mail": key,
+		"dir":  string(d),
+	}).Info("Rescued from Junk folder")
+
+	return recordHistory(NewBoltStore(db), entry)
+}
+
+// isRescued reports whether the mail has been rescued from the Junk folder
+// before. Such mails are never classified again.
+func isRescued(s Store, key string) (rescued bool, err error) {
+	err = s.View(func(tx Tx) error {
+		rescued = tx.Get("Rescued", key) != nil
+		return nil
+	})
+
+	return rescued, err
+}
+
+// Rescue moves mails that were wrongly classified as junk back to the
+// inbox, learns them as good and records the correction in the history. It
+// returns the keys of all rescued mails.
+func (d Maildir) Rescue(db *bolt.DB, r RescueRequest) (rescued []string, err error) {
+
+	keys, err := movedToJunk(db, r)
+	if err != nil {
+		return rescued, err
+	}
+	if len(keys) == 0 {
+		return rescued, errors.New("no mail to rescue found")
+	}
+
+	for _, key := range keys {
+		err = d.rescue(db, key, r.KeepFlags)
+		if err != nil {
+			return rescued, err
+		}
+		rescued = append(rescued, key)
+	}
+
+	return rescued, nil
+}
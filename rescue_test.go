@@ -0,0 +1,104 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rescue", func() {
+
+	Context("Rescue a mail wrongly moved to junk", func() {
+		BeforeEach(func() {
+			err = LoadMaildirs([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+				Junk: true,
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			}
+			err = m.Learn(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			raw, err := ioutil.ReadFile("test/Maildir/.Junk/cur/1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M3P3.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1500000000.M3P3.test",
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(m.Junk).Should(BeTrue())
+		})
+		AfterEach(func() {
+			CloseDatabases(dbs)
+
+			os.Remove("test/Maildir/new/1500000000.M3P3.test")
+			os.Remove("test/Maildir/.Junk/cur/1500000000.M3P3.test")
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Moves the last mail back to new and records it", func() {
+			rescued, err := Maildir("test/Maildir").Rescue(dbs["test/Maildir"], RescueRequest{
+				Last: 1,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(rescued).Should(Equal([]string{"1500000000.M3P3.test"}))
+
+			_, err = os.Stat("test/Maildir/new/1500000000.M3P3.test")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{
+				Key: "1500000000.M3P3.test",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(2))
+			Ω(entries[0].Action).Should(Equal(ActionMoved))
+			Ω(entries[1].Action).Should(Equal(ActionRescued))
+		})
+
+		It("Never classifies a rescued mail again", func() {
+			_, err := Maildir("test/Maildir").Rescue(dbs["test/Maildir"], RescueRequest{
+				Key: "1500000000.M3P3.test",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1500000000.M3P3.test",
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = os.Stat("test/Maildir/new/1500000000.M3P3.test")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Skips mails rescued before", func() {
+			_, err := Maildir("test/Maildir").Rescue(dbs["test/Maildir"], RescueRequest{
+				Last: 1,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = Maildir("test/Maildir").Rescue(dbs["test/Maildir"], RescueRequest{
+				Last: 1,
+			})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
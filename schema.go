@@ -0,0 +1,280 @@
+package sisyphus
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// SchemaVersion is the version of the database layout written by this
+	// release of sisyphus. Version 2 may store keyed hashes instead of
+	// words, see HashTokens, version 3 encrypted values, see
+	// SetEncryptionKeys, and version 4 exact counters or count-min sketches
+	// along with the mails learned, see SetCounting.
+	SchemaVersion = 4
+
+	// TokenizerVersion identifies the rules used to turn a mail into words.
+	// Words learned with a different tokenizer do not mix well with the
+	// current ones, so such databases should be rebuilt.
+	TokenizerVersion = 1
+)
+
+// ErrSchemaTooNew is returned when a database has been written by a newer
+// release of sisyphus.
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+// Meta holds the metadata stored alongside the learned words. Created is
+// zero for databases created before schema versioning was introduced.
+// TokenHash identifies the secret words are hashed with, and is empty if
+// they are stored in cleartext. EncryptionKey identifies the key the word
+// lists and statistics are encrypted with, and is empty if they are not.
+// Counter is the counting model, see ParseCounting. PrunedTokens is the
+// number of words dropped by PruneTokens so far. Features are the features
+// learned, see SetFeatures, and Language the language words are reduced in,
+// see SetLanguage.
+type Meta struct {
+	Schema        int
+	Tokenizer     int
+	Created       time.Time
+	LastLearned   time.Time
+	TokenHash     string
+	EncryptionKey string
+	Counter       string
+	PrunedTokens  int
+	Features      string
+	Language      string
+}
+
+// migrations upgrade the database layout. The migration at index i brings a
+// database from version i to version i+1.
+var migrations = []func(tx *bolt.Tx) error{
+	migrateV1,
+	migrateV2,
+	migrateV3,
+	migrateV4,
+}
+
+// migrateV1 adds the Meta bucket to databases created before schema
+// versioning was introduced. Those were learned with the first tokenizer and
+// their creation time is unknown.
+func migrateV1(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte("TokenizerVersion"), []byte("1"))
+}
+
+// migrateV2 marks databases that may store keyed hashes instead of words, so
+// older releases, which would look the words up in cleartext, refuse them.
+// Databases of version 1 store their words in cleartext, which version 2
+// still reads, so nothing is converted.
+func migrateV2(tx *bolt.Tx) error {
+	return nil
+}
+
+// migrateV3 marks databases that may store encrypted word lists and
+// statistics, so older releases, which would take them for corrupt sketches,
+// refuse them. Values are encrypted when the database is opened with a key,
+// not here.
+func migrateV3(tx *bolt.Tx) error {
+	return nil
+}
+
+// migrateV4 marks databases that may count with other models than
+// HyperLogLog sketches and keep the mails learned in Learned buckets, so
+// older releases refuse them. Databases of version 3 count with the sketches
+// they were created with, which is recorded.
+func migrateV4(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("Meta"))
+	if b.Get([]byte("Counter")) != nil {
+		return nil
+	}
+
+	return b.Put([]byte("Counter"), []byte(legacyCounting.String()))
+}
+
+// initMeta writes the metadata of a newly created database.
+func initMeta(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte("SchemaVersion"), []byte(strconv.Itoa(SchemaVersion)))
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte("TokenizerVersion"), []byte(strconv.Itoa(TokenizerVersion)))
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte("Counter"), []byte(counting.String()))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte("Created"), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// metaInt reads an integer value from the Meta bucket, returning 0 if the
+// key is not present.
+func metaInt(b *bolt.Bucket, key string) (i int, err error) {
+	raw := b.Get([]byte(key))
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	return strconv.Atoi(string(raw))
+}
+
+// metaTime reads a time from the Meta bucket, returning the zero time if the
+// key is not present.
+func metaTime(b *bolt.Bucket, key string) (t time.Time, err error) {
+	raw := b.Get([]byte(key))
+	if len(raw) == 0 {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, string(raw))
+}
+
+// readMeta reads the metadata of a database. Databases without a Meta bucket
+// are reported as schema version 0, learned with the first tokenizer.
+func readMeta(tx *bolt.Tx) (meta Meta, err error) {
+	b := tx.Bucket([]byte("Meta"))
+	if b == nil {
+		meta.Tokenizer = 1
+		meta.Counter = legacyCounting.String()
+		meta.Features = FeatureWords
+		meta.Language = LanguageNone
+		return meta, nil
+	}
+
+	meta.Schema, err = metaInt(b, "SchemaVersion")
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Tokenizer, err = metaInt(b, "TokenizerVersion")
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Created, err = metaTime(b, "Created")
+	if err != nil {
+		return meta, err
+	}
+
+	meta.LastLearned, err = metaTime(b, "LastLearned")
+	if err != nil {
+		return meta, err
+	}
+
+	meta.TokenHash = string(b.Get([]byte("TokenHash")))
+	meta.EncryptionKey = string(b.Get([]byte("EncryptionKey")))
+
+	meta.PrunedTokens, err = metaInt(b, "PrunedTokens")
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Counter = legacyCounting.String()
+	if raw := b.Get([]byte("Counter")); raw != nil {
+		meta.Counter = string(raw)
+	}
+
+	meta.Features = FeatureWords
+	if raw := b.Get([]byte("Features")); raw != nil {
+		meta.Features = string(raw)
+	}
+
+	meta.Language = LanguageNone
+	if raw := b.Get([]byte("Language")); raw != nil {
+		meta.Language = string(raw)
+	}
+
+	return meta, nil
+}
+
+// ReadMeta returns the metadata of a database
+func ReadMeta(db *bolt.DB) (meta Meta, err error) {
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		meta, err = readMeta(tx)
+		return err
+	})
+
+	return meta, err
+}
+
+// checkSchema refuses databases written by a newer release of sisyphus.
+func checkSchema(db *bolt.DB) error {
+	meta, err := ReadMeta(db)
+	if err != nil {
+		return err
+	}
+
+	if meta.Schema > SchemaVersion {
+		return ErrSchemaTooNew
+	}
+
+	return nil
+}
+
+// migrate upgrades the database to the current schema version, running each
+// migration in its own transaction. It returns the version found before
+// migrating.
+func migrate(db *bolt.DB) (from int, err error) {
+	meta, err := ReadMeta(db)
+	if err != nil {
+		return from, err
+	}
+	from = meta.Schema
+
+	if from > SchemaVersion {
+		return from, ErrSchemaTooNew
+	}
+
+	for v := from; v < SchemaVersion; v++ {
+		err = db.Update(func(tx *bolt.Tx) error {
+			err := migrations[v](tx)
+			if err != nil {
+				return err
+			}
+
+			b := tx.Bucket([]byte("Meta"))
+			return b.Put([]byte("SchemaVersion"), []byte(strconv.Itoa(v+1)))
+		})
+		if err != nil {
+			return from, err
+		}
+
+		log.WithFields(log.Fields{
+			"from": v,
+			"to":   v + 1,
+		}).Info("Database migrated")
+	}
+
+	meta, err = ReadMeta(db)
+	if err != nil {
+		return from, err
+	}
+	if meta.Tokenizer != TokenizerVersion {
+		log.WithFields(log.Fields{
+			"learned": meta.Tokenizer,
+			"current": TokenizerVersion,
+		}).Warning("Database was learned with a different tokenizer, please rebuild it")
+	}
+
+	return from, nil
+}
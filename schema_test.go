@@ -0,0 +1,99 @@
+package sisyphus_test
+
+import (
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema", func() {
+
+	Context("Versioning", func() {
+		AfterEach(func() {
+			err = os.Remove("test/Maildir/sisyphus.db")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Writes the current versions into a new database", func() {
+			dbs, err := LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			defer CloseDatabases(dbs)
+
+			meta, err := ReadMeta(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(meta.Schema).Should(Equal(SchemaVersion))
+			Ω(meta.Tokenizer).Should(Equal(TokenizerVersion))
+			Ω(meta.Created.IsZero()).Should(BeFalse())
+		})
+
+		It("Migrates a database without metadata", func() {
+			db, err := bolt.Open("test/Maildir/sisyphus.db", 0600, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketIfNotExists([]byte("Statistics"))
+				return err
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			db.Close()
+
+			dbs, err := LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			defer CloseDatabases(dbs)
+
+			meta, err := ReadMeta(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(meta.Schema).Should(Equal(SchemaVersion))
+		})
+
+		It("Migrates a database of the first version", func() {
+			db, err := bolt.Open("test/Maildir/sisyphus.db", 0600, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("SchemaVersion"), []byte("1"))
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			db.Close()
+
+			dbs, err := LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).ShouldNot(HaveOccurred())
+			defer CloseDatabases(dbs)
+
+			meta, err := ReadMeta(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(meta.Schema).Should(Equal(SchemaVersion))
+			Ω(meta.Schema).Should(BeNumerically(">", 1))
+
+			// the counting of old databases is recorded
+			err = dbs["test/Maildir"].View(func(tx *bolt.Tx) error {
+				Ω(string(tx.Bucket([]byte("Meta")).Get([]byte("Counter")))).Should(Equal("hll:14"))
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Refuses a database with a newer schema", func() {
+			db, err := bolt.Open("test/Maildir/sisyphus.db", 0600, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("SchemaVersion"), []byte("999"))
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			db.Close()
+
+			_, err = LoadDatabases([]Maildir{"test/Maildir"})
+			Ω(err).Should(Equal(ErrSchemaTooNew))
+		})
+	})
+})
@@ -0,0 +1,323 @@
+package sisyphus
+
+import (
+	"bufio"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/carlostrub/maildir"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Verdicts of the sender lists on a mail
+const (
+	SenderUnknown = ""
+	SenderAllowed = "allowed"
+	SenderBlocked = "blocked"
+)
+
+// Files in the Maildir listing allowed and blocked senders, one pattern per
+// line. A pattern is an address (boss@example.com), a domain including its
+// subdomains (example.com or @example.com) or a wildcard pattern
+// (*@*.example.com). Empty lines and lines starting with # are ignored.
+const (
+	allowFile = "sisyphus.allow"
+	blockFile = "sisyphus.block"
+)
+
+// SenderLists are the allowed and blocked sender patterns of a Maildir
+type SenderLists struct {
+	Allow, Block []string
+}
+
+// readPatterns reads a file of sender patterns. A missing file is an empty
+// list.
+func readPatterns(path string) (patterns []string, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return patterns, nil
+	}
+	if err != nil {
+		return patterns, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		p := strings.ToLower(strings.TrimSpace(s.Text()))
+		if p == "" || p[0] == '#' {
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+
+	return patterns, s.Err()
+}
+
+// SenderLists reads the allowed and blocked sender patterns of the Maildir
+func (d Maildir) SenderLists() (l SenderLists, err error) {
+	l.Allow, err = readPatterns(filepath.Join(string(d), allowFile))
+	if err != nil {
+		return l, err
+	}
+
+	l.Block, err = readPatterns(filepath.Join(string(d), blockFile))
+
+	return l, err
+}
+
+// matchSender reports whether the lower case address matches the pattern
+func matchSender(pattern, address string) bool {
+	if strings.HasPrefix(pattern, "@") {
+		pattern = pattern[1:]
+	}
+
+	if strings.Contains(pattern, "@") {
+		ok, _ := path.Match(pattern, address)
+		return ok
+	}
+
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return false
+	}
+	domain := address[i+1:]
+
+	ok, _ := path.Match(pattern, domain)
+	if !ok {
+		ok, _ = path.Match("*."+pattern, domain)
+	}
+
+	return ok
+}
+
+// matchAny reports whether the address matches any of the patterns
+func matchAny(patterns []string, address string) bool {
+	for _, p := range patterns {
+		if matchSender(p, address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sender returns the lower case address of the first sender in a header, or
+// an empty string if there is none.
+func sender(header *mail.Header) string {
+	from, err := header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return ""
+	}
+
+	return strings.ToLower(from[0].Address)
+}
+
+// senderVerdict consults the sender lists of the Maildir about an address.
+// Explicitly allowed senders win over blocked ones, and blocked senders win
+// over the automatic allowlist.
+func (d Maildir) senderVerdict(s Store, address string) (verdict string, err error) {
+	if address == "" {
+		return SenderUnknown, nil
+	}
+
+	l, err := d.SenderLists()
+	if err != nil {
+		return SenderUnknown, err
+	}
+
+	switch {
+	case matchAny(l.Allow, address):
+		return SenderAllowed, nil
+	case matchAny(l.Block, address):
+		return SenderBlocked, nil
+	}
+
+	err = s.View(func(tx Tx) error {
+		if tx.Get("Allowlist", address) != nil {
+			verdict = SenderAllowed
+		}
+		return nil
+	})
+
+	return verdict, err
+}
+
+// addresses returns the lower case addresses found in the given header
+// fields. Fields that cannot be parsed are skipped.
+func addresses(header *mail.Header, fields ...string) (a []string) {
+	for _, f := range fields {
+		list, err := header.AddressList(f)
+		if err != nil {
+			continue
+		}
+		for _, v := range list {
+			a = append(a, strings.ToLower(v.Address))
+		}
+	}
+
+	return a
+}
+
+// replied returns the keys of the mails in the cur directory of a folder
+// that carry the Maildir R flag.
+func replied(folder string) (keys []string, err error) {
+	mails, err := readCur(folder)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return keys, err
+	}
+
+	for _, m := range mails {
+		if strings.ContainsRune(m.Flags, 'R') {
+			keys = append(keys, m.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+// LearnSenders builds the automatic allowlist of the Maildir from the
+// recipients of the mails in its Sent folder and the senders of the mails
+// the user replied to. The addresses the Sent mails are from are the user's
+// own, so they are never allowed; otherwise anyone could skip the filter by
+// forging them. Addresses found again are stamped with the current time, see
+// PruneAllowlist. It returns the number of addresses found.
+func (d Maildir) LearnSenders(db *bolt.DB) (n int, err error) {
+
+	found := make(map[string]bool)
+	own := make(map[string]bool)
+
+	sent := filepath.Join(string(d), layout.Sent)
+	if _, err := os.Stat(filepath.Join(sent, "cur")); err == nil {
+		keys, err := maildir.Dir(sent).Keys()
+		if err != nil {
+			return n, err
+		}
+		for _, k := range keys {
+			header, err := maildir.Dir(sent).Header(k)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err":  err,
+					"mail": k,
+				}).Warning("Cannot read sent mail")
+				continue
+			}
+			for _, a := range addresses(header, "To", "Cc", "Bcc") {
+				found[a] = true
+			}
+			for _, a := range addresses(header, "From") {
+				own[a] = true
+			}
+		}
+	}
+
+	keys, err := replied(string(d))
+	if err != nil {
+		return n, err
+	}
+	for _, k := range keys {
+		header, err := maildir.Dir(d).Header(k)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"mail": k,
+			}).Warning("Cannot read replied mail")
+			continue
+		}
+		if a := sender(header); a != "" {
+			found[a] = true
+		}
+	}
+
+	for a := range own {
+		delete(found, a)
+	}
+
+	now := []byte(time.Now().UTC().Format(time.RFC3339))
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Allowlist"))
+		for a := range own {
+			err := b.Delete([]byte(a))
+			if err != nil {
+				return err
+			}
+		}
+		for a := range found {
+			err := b.Put([]byte(a), now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	log.WithFields(log.Fields{
+		"dir":       string(d),
+		"addresses": len(found),
+	}).Info("Senders learned")
+
+	return len(found), nil
+}
+
+// PruneAllowlist removes the automatically allowed senders not found by
+// LearnSenders since the given time, e.g. because the mails they were found
+// in have been deleted. It returns the number of senders removed.
+func PruneAllowlist(db *bolt.DB, before time.Time) (n int, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Allowlist"))
+
+		var old [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			t, err := time.Parse(time.RFC3339, string(v))
+			if err == nil && t.Before(before) {
+				old = append(old, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys cannot be deleted while iterating
+		for _, k := range old {
+			err = b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(old)
+
+		return nil
+	})
+
+	return n, err
+}
+
+// Allowlist returns the automatically allowed senders of a database
+func Allowlist(db *bolt.DB) (senders []string, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Allowlist"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, _ []byte) error {
+			senders = append(senders, string(k))
+			return nil
+		})
+	})
+
+	return senders, err
+}
@@ -0,0 +1,133 @@
+package sisyphus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Senders", func() {
+
+	var raw []byte
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		raw, err = ioutil.ReadFile("test/Maildir/cur/1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119:2,Sa")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	Context("Classify mails of listed senders", func() {
+		BeforeEach(func() {
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M4P4.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.Remove("test/Maildir/sisyphus.allow")
+			os.Remove("test/Maildir/sisyphus.block")
+			err = os.Remove("test/Maildir/new/1500000000.M4P4.test")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Junks mails from blocked domains", func() {
+			err = ioutil.WriteFile("test/Maildir/sisyphus.block", []byte("# no FreeBSD\n@FreeBSD.org\n"), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:    "1500000000.M4P4.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(m.Junk).Should(BeTrue())
+
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(1))
+			Ω(entries[0].Sender).Should(Equal(SenderBlocked))
+			Ω(entries[0].Score).Should(BeNil())
+		})
+
+		It("Prefers allowed addresses over blocked domains", func() {
+			err = ioutil.WriteFile("test/Maildir/sisyphus.block", []byte("*.org\n"), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/sisyphus.allow", []byte("danfe@freebsd.org\n"), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key:    "1500000000.M4P4.test",
+				DryRun: true,
+			}
+			err = m.Classify(dbs["test/Maildir"], "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(m.Junk).Should(BeFalse())
+
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries[0].Sender).Should(Equal(SenderAllowed))
+		})
+	})
+
+	Context("Build the automatic allowlist", func() {
+		BeforeEach(func() {
+			sent := "From: Me <me@example.com>\nTo: friend@example.org, me@example.com\nCc: ports-committers@freebsd.org\nSubject: Hello\n\nHello\n"
+			err = os.MkdirAll("test/Maildir/.Sent/cur", 0700)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/.Sent/cur/1500000000.M5P5.test:2,S", []byte(sent), 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/cur/1500000000.M6P6.test:2,RS", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			err = os.RemoveAll("test/Maildir/.Sent")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = os.Remove("test/Maildir/cur/1500000000.M6P6.test:2,RS")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Allows recipients of sent mails and senders of replied mails", func() {
+			n, err := Maildir("test/Maildir").LearnSenders(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(BeNumerically(">", 1))
+
+			senders, err := Allowlist(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(senders).Should(ContainElement("friend@example.org"))
+			Ω(senders).Should(ContainElement("ports-committers@freebsd.org"))
+			Ω(senders).Should(ContainElement("danfe@freebsd.org"))
+			Ω(senders).ShouldNot(ContainElement("me@example.com"))
+		})
+
+		It("Drops senders not found for long", func() {
+			_, err = Maildir("test/Maildir").LearnSenders(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := PruneAllowlist(dbs["test/Maildir"], time.Now().Add(-time.Hour))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(BeZero())
+
+			n, err = PruneAllowlist(dbs["test/Maildir"], time.Now().Add(time.Hour))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(BeNumerically(">", 1))
+
+			senders, err := Allowlist(dbs["test/Maildir"])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(senders).Should(BeEmpty())
+		})
+	})
+})
@@ -2,16 +2,24 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/carlostrub/sisyphus"
 )
@@ -20,6 +28,9 @@ var (
 	version string
 )
 
+// lockTimeout is how long to wait for a database held by another process
+const lockTimeout = 10 * time.Second
+
 func main() {
 
 	// Define App
@@ -50,7 +61,114 @@ func main() {
 
   SISYPHUS_DURATION: Interval between learning periods, e.g. 12h. Default is set to 24h.
 
+  SISYPHUS_BACKUPS:  Number of database backups to keep per maildir. A new
+                     backup is written before every learning period.
+                     Default is set to 3.
+
+  SISYPHUS_HISTORY:  How long classification decisions are kept in the
+                     history, e.g. 720h. Default is set to 2160h (90 days).
+
+  SISYPHUS_ALLOWLIST_AGE: How long senders stay on the automatic allowlist
+                     once no mail in the maildir allows them any more, e.g.
+                     4320h. Default is set to 8760h (365 days).
+
   SISYPHUS_DRY_RUN : If set, sisyphus will not move any mails around.
+
+  SISYPHUS_HALF_LIFE: If set, classification weights the months mails were
+                     learned in by their age, halving the weight for every
+                     half-life, e.g. 4320h (about 6 months).
+
+  SISYPHUS_RETENTION: If set, classification ignores months of learned mails
+                     older than this, and they are dropped after every
+                     learning period, e.g. 17520h (about 2 years). The
+                     all-time counts keep every mail learned.
+
+  SISYPHUS_COMPACT:  If set, databases whose files consist of at least this
+                     fraction of free pages are compacted after every
+                     learning period, e.g. 0.5.
+
+  SISYPHUS_PRUNE_HAPAXES: If set, words learned from a single mail are
+                     dropped after every learning period once they have
+                     not been learned for this long, e.g. 2160h. Requires
+                     SISYPHUS_HALF_LIFE or SISYPHUS_RETENTION.
+
+  SISYPHUS_PRUNE_KEEP: If set, only this number of the most informative
+                     words is kept after every learning period.
+
+  SISYPHUS_PRUNE_SIZE: If set, the least informative words are dropped and
+                     the database is compacted after every learning period
+                     in which its data takes more than this number of bytes.
+
+  SISYPHUS_TOKEN_SECRET: A secret of 16 to 32 bytes, hex or base64 encoded.
+                     If set, databases store keyed hashes of the words
+                     learned instead of the words themselves. Existing
+                     databases are converted; this cannot be undone.
+
+  SISYPHUS_TOKEN_SECRET_FILE: File holding the secret as in
+                     SISYPHUS_TOKEN_SECRET, used instead of it.
+
+  SISYPHUS_COUNTER:  How new databases count the mails each word was
+                     learned from: hll with an optional precision from 4
+                     to 16, e.g. hll:12, exact, or a count-min sketch of
+                     a fixed size, e.g. cms:4x16384. Exact counters and
+                     count-min sketches can unlearn mails. Default is
+                     hll:14; rebuild a database to change its counting.
+
+  SISYPHUS_FEATURES: Features learned from mails: words for single words
+                     only, bigrams to add pairs of adjacent words, or osb
+                     to add orthogonal sparse bigrams of words up to four
+                     apart. Comma-separated entries of the form
+                     MAILDIR=features set the features of one maildir,
+                     e.g. osb,/home/a/Maildir=words. Default is words.
+
+  SISYPHUS_LANGUAGE: Language whose stop words are dropped and whose words
+                     are reduced to their stems: en, de, fr, it or es,
+                     auto to detect the language of every mail, or none.
+                     Set per maildir like SISYPHUS_FEATURES. Rebuild a
+                     database after changing its language. Default is none.
+
+  SISYPHUS_KEY:      Comma-separated AES keys, hex or base64 encoded, to
+                     encrypt the word lists, statistics and backups with,
+                     e.g. from openssl rand -hex 32. The first key
+                     encrypts, all keys decrypt. To rotate keys, put a new
+                     key first; databases are re-encrypted when opened.
+                     Rotate after a few million mails learned at the
+                     latest.
+
+  SISYPHUS_KEY_FILE: File holding the keys as in SISYPHUS_KEY, one per
+                     line, used instead of SISYPHUS_KEY.
+
+  SISYPHUS_JUNK_FOLDER: Maildir++ folder junk mails are moved to. Default is
+                     set to .Junk.
+
+  SISYPHUS_SENT_FOLDER: Maildir++ folder whose recipients are allowed as
+                     senders. Default is set to .Sent.
+
+  SISYPHUS_GOOD_FOLDERS: Comma-separated globs of further Maildir++ folders
+                     learned as good, e.g. .Archive.*
+
+  SISYPHUS_JUNK_FOLDERS: Comma-separated globs of further Maildir++ folders
+                     learned as junk.
+
+  SISYPHUS_EXCLUDE_FOLDERS: Comma-separated globs of Maildir++ folders never
+                     learned from, e.g. .Archive.Private*
+
+  SISYPHUS_FLAGS:    How good mails are learned depending on their Maildir
+                     flags, as a comma-separated list of a flag and either
+                     a weight or skip. Default is set to R=2,P=2,F=2,T=skip,
+                     so mails replied to, forwarded or flagged count twice
+                     and trashed mails are not learned.
+
+  SISYPHUS_METRICS:  If set, address to export metrics for Prometheus on,
+                     e.g. localhost:9321. Metrics are served at /metrics.
+
+  SISYPHUS_SHARED_DB: Path to a site-wide database consulted in addition to
+                     each maildir's database when classifying, e.g. one
+                     written by merge. It must learn the same features in
+                     the same language as the maildirs.
+
+  SISYPHUS_SHARED_WEIGHT: Weight of the shared database's counts relative to
+                     the maildir's counts. Default is set to 1.
 			`,
 		}
 	}
@@ -80,6 +198,41 @@ COPYRIGHT:
   {{.Copyright}}
 `
 
+	app.Before = func(c *cli.Context) error {
+		err := loadLayout()
+		if err != nil {
+			return err
+		}
+
+		err = loadTokenSecret()
+		if err != nil {
+			return err
+		}
+
+		err = loadKeys()
+		if err != nil {
+			return err
+		}
+
+		if v, ok := os.LookupEnv("SISYPHUS_COUNTER"); ok {
+			c, err := sisyphus.ParseCounting(v)
+			if err != nil {
+				return err
+			}
+			err = sisyphus.SetCounting(c)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = loadTokenizer()
+		if err != nil {
+			return err
+		}
+
+		return loadDecay()
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:    "run",
@@ -113,7 +266,37 @@ COPYRIGHT:
 						"err": err,
 					}).Fatal("Cannot load databases")
 				}
-				defer sisyphus.CloseDatabases(dbs)
+
+				// Databases may be replaced while running, e.g. when
+				// compacted, so they are only used through handles
+				handles := make(map[sisyphus.Maildir]*sisyphus.Handle)
+				for d, db := range dbs {
+					handles[d] = sisyphus.NewHandle(db)
+					defer handles[d].Close()
+				}
+
+				// Answer requests for live statistics
+				for _, d := range maildirs {
+					l, err := d.Serve(handles[d])
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err":     err,
+							"maildir": string(d),
+						}).Error("Cannot listen for control requests")
+						continue
+					}
+					defer l.Close()
+				}
+
+				shared := loadShared(dbs)
+				if shared != nil {
+					defer shared.DB.Close()
+				}
+
+				// Export metrics if requested
+				if addr, ok := os.LookupEnv("SISYPHUS_METRICS"); ok {
+					serveMetrics(addr, maildirs, handles)
+				}
 
 				// Learn at startup and regular intervals
 				go func() {
@@ -123,8 +306,15 @@ COPYRIGHT:
 							log.Fatal("Cannot parse duration for learning intervals.")
 						}
 
-						backup(maildirs, dbs)
-						learn(maildirs, dbs)
+						start := time.Now()
+						backup(maildirs, handles)
+						learn(maildirs, handles)
+						expire(maildirs, handles)
+						pruneTokens(maildirs, handles)
+						pruneHistory(maildirs, handles)
+						compact(maildirs, handles)
+						sisyphus.LearnCycleDuration.Observe(time.Since(start).Seconds())
+
 						time.Sleep(duration)
 					}
 				}()
@@ -138,29 +328,45 @@ COPYRIGHT:
 				}
 				defer watcher.Close()
 
+				// New mails are queued, so the watcher never blocks while
+				// a mail is classified
+				queue := make(chan string, 1000)
+				go func() {
+					for name := range queue {
+						sisyphus.QueueLength.Set(float64(len(queue)))
+
+						path := strings.Split(name, "/new/")
+
+						_, dryRun := os.LookupEnv("SISYPHUS_DRY_RUN")
+						m := sisyphus.Mail{
+							Key:    path[1],
+							DryRun: dryRun,
+							Shared: shared,
+						}
+
+						d := sisyphus.Maildir(path[0])
+						err := handles[d].Use(func(db *bolt.DB) error {
+							return m.Classify(db, d)
+						})
+						if err != nil {
+							log.WithFields(log.Fields{
+								"err": err,
+							}).Error("Classify mail")
+						}
+					}
+				}()
+
 				done := make(chan bool)
 				go func() {
 					for {
 						select {
 						case event := <-watcher.Events:
 							if event.Op&fsnotify.Create == fsnotify.Create {
-								path := strings.Split(event.Name, "/new/")
-
-								_, dryRun := os.LookupEnv("SISYPHUS_DRY_RUN")
-								m := sisyphus.Mail{
-									Key:    path[1],
-									DryRun: dryRun,
-								}
-
-								err = m.Classify(dbs[sisyphus.Maildir(path[0])], sisyphus.Maildir(path[0]))
-								if err != nil {
-									log.WithFields(log.Fields{
-										"err": err,
-									}).Error("Classify mail")
-								}
-
+								queue <- event.Name
+								sisyphus.QueueLength.Set(float64(len(queue)))
 							}
 						case err := <-watcher.Errors:
+							sisyphus.WatcherErrors.Inc()
 							log.WithFields(log.Fields{
 								"err": err,
 							}).Error("Problem with directory watcher")
@@ -185,116 +391,1401 @@ COPYRIGHT:
 			Name:    "stats",
 			Aliases: []string{"i"},
 			Usage:   "show statistics",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format, either table or json",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				output := c.String("output")
+				if output != "table" && output != "json" {
+					log.Fatal("Output format must be table or json.")
+				}
+
+				maildirs := loadConfig()
+
+				for _, d := range maildirs {
+					s, err := d.LiveStats()
+					if err == sisyphus.ErrNoDaemon {
+						// No daemon running, read the database directly
+						s, err = offlineStats(d)
+					}
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err":     err,
+							"maildir": string(d),
+						}).Error("Cannot load statistics")
+						continue
+					}
+
+					if output == "json" {
+						err = json.NewEncoder(os.Stdout).Encode(struct {
+							Maildir string `json:"maildir"`
+							sisyphus.Statistics
+						}{string(d), s})
+					} else {
+						err = printStats(os.Stdout, d, s)
+					}
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot print statistics")
+					}
+				}
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "upgrade databases to the current schema",
 			Action: func(c *cli.Context) {
 
 				maildirs := loadConfig()
 
-				// Open all backup databases
-				dbs, err := sisyphus.LoadBackupDatabases(maildirs)
+				// Opening the databases runs all pending migrations
+				dbs, err := sisyphus.LoadDatabases(maildirs)
 				if err != nil {
 					log.WithFields(log.Fields{
 						"err": err,
-					}).Fatal("Cannot load backup databases")
+					}).Fatal("Cannot load databases")
 				}
 				defer sisyphus.CloseDatabases(dbs)
 
-				for _, db := range dbs {
-					gTotal, jTotal, gWords, jWords := sisyphus.Info(db)
+				for dir, db := range dbs {
+					meta, err := sisyphus.ReadMeta(db)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err":     err,
+							"maildir": string(dir),
+						}).Error("Cannot read database metadata")
+						continue
+					}
 					log.WithFields(log.Fields{
-						"good mails learned":   gTotal,
-						"junk mails learned":   jTotal,
-						"number of good words": gWords,
-						"number of junk words": jWords,
-					}).Info("Statistics")
+						"maildir":   string(dir),
+						"schema":    meta.Schema,
+						"tokenizer": meta.Tokenizer,
+						"counter":   meta.Counter,
+						"features":  meta.Features,
+						"language":  meta.Language,
+						"created":   meta.Created,
+					}).Info("Database is up to date")
 				}
 			},
 		},
-	}
+		{
+			Name:  "export",
+			Usage: "export the learned model as JSON lines",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose model is exported",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "file to write to instead of standard output",
+				},
+			},
+			Action: func(c *cli.Context) {
 
-	app.Run(os.Args)
-}
+				maildir := loadMaildir(c)
 
-// learn invokes the learning process for a slice of maildirs
-func learn(maildirs []sisyphus.Maildir, dbs map[sisyphus.Maildir]*bolt.DB) {
-	mails, err := sisyphus.LoadMails(maildirs)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"err": err,
-		}).Fatal("Cannot load mails")
-	}
-	for _, d := range maildirs {
-		db := dbs[d]
-		m := mails[d]
-		for _, val := range m {
-			err := val.Learn(db, d)
-			if err != nil {
+				dbs, err := sisyphus.LoadDatabases([]sisyphus.Maildir{maildir})
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot load databases")
+				}
+				defer sisyphus.CloseDatabases(dbs)
+
+				out := os.Stdout
+				if c.IsSet("output") {
+					out, err = os.Create(c.String("output"))
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot create export file")
+					}
+					defer out.Close()
+				}
+
+				w := bufio.NewWriter(out)
+				err = sisyphus.Export(dbs[maildir], w)
+				if err == nil {
+					err = w.Flush()
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot export model")
+				}
+			},
+		},
+		{
+			Name:  "import",
+			Usage: "import a model written by export",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose model is replaced",
+				},
+				cli.StringFlag{
+					Name:  "input, i",
+					Usage: "file to read from instead of standard input",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+
+				dbs, err := sisyphus.LoadDatabases([]sisyphus.Maildir{maildir})
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot load databases")
+				}
+				defer sisyphus.CloseDatabases(dbs)
+
+				in := os.Stdin
+				if c.IsSet("input") {
+					in, err = os.Open(c.String("input"))
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot open import file")
+					}
+					defer in.Close()
+				}
+
+				err = sisyphus.Import(dbs[maildir], in)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot import model")
+				}
 				log.WithFields(log.Fields{
-					"err":  err,
-					"mail": val.Key,
-				}).Warning("Cannot learn mail")
-			}
-		}
-	}
-	log.Info("All mails learned")
+					"maildir": string(maildir),
+				}).Info("Model imported")
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "show the history of classification decisions",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose history is shown",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "only decisions since a time (RFC 3339) or duration ago, e.g. 24h",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "only decisions until a time (RFC 3339) or duration ago",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "only mails from senders containing this text",
+				},
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "only the mail with this key",
+				},
+				cli.StringFlag{
+					Name:  "message-id",
+					Usage: "only the mail with this Message-ID",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format, either table or json",
+				},
+			},
+			Action: func(c *cli.Context) {
 
-	return
-}
+				output := c.String("output")
+				if output != "table" && output != "json" {
+					log.Fatal("Output format must be table or json.")
+				}
 
-// backup creates a backup copy of the existing database
-func backup(maildirs []sisyphus.Maildir, dbs map[sisyphus.Maildir]*bolt.DB) {
-	for _, d := range maildirs {
-		db := dbs[d]
+				maildir := loadMaildir(c)
 
-		backup, err := os.Create(filepath.Join(string(d), "sisyphus.db.backup"))
+				filter := sisyphus.HistoryFilter{
+					Since:     parseTime(c.String("since")),
+					Until:     parseTime(c.String("until")),
+					From:      c.String("from"),
+					Key:       c.String("key"),
+					MessageID: c.String("message-id"),
+				}
 
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-			}).Error("Backup creation")
-		}
-		defer backup.Close()
+				entries, err := maildir.LiveHistory(filter)
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, read the database directly
+					entries, err = offlineHistory(maildir, filter)
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot read history")
+				}
+
+				if output == "json" {
+					enc := json.NewEncoder(os.Stdout)
+					for _, e := range entries {
+						err = enc.Encode(e)
+						if err != nil {
+							log.WithFields(log.Fields{
+								"err": err,
+							}).Fatal("Cannot print history")
+						}
+					}
+					return
+				}
 
-		w := bufio.NewWriter(backup)
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "TIME\tJUNK\tSCORE\tACTION\tFROM\tKEY")
+				for _, e := range entries {
+					score := "-"
+					if e.Score != nil {
+						score = fmt.Sprintf("%.2f", *e.Score)
+					}
+					fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.RFC3339), e.Junk, score, e.Action, e.From, e.Key)
+				}
+				w.Flush()
+			},
+		},
+		{
+			Name:  "learn",
+			Usage: "learn mails from corpora once, without moving any files",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database learns the mails",
+				},
+				cli.StringSliceFlag{
+					Name:  "good",
+					Usage: "directory or mbox file of good mails, may be repeated",
+				},
+				cli.StringSliceFlag{
+					Name:  "junk",
+					Usage: "directory or mbox file of junk mails, may be repeated",
+				},
+			},
+			Action: func(c *cli.Context) {
 
-		err = db.View(func(tx *bolt.Tx) error {
-			_, err := tx.WriteTo(w)
-			return err
-		})
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-			}).Error("Backup creation")
-		}
+				if len(c.StringSlice("good")) == 0 && len(c.StringSlice("junk")) == 0 {
+					log.Fatal("Please give at least one corpus with --good or --junk.")
+				}
 
-		w.Flush()
-	}
+				maildir := loadMaildir(c)
 
-	log.Info("All databases backed up successfully.")
+				db, err := sisyphus.OpenDatabase(string(maildir))
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot open database")
+				}
+				defer db.Close()
 
-	return
-}
+				corpora := []struct {
+					paths []string
+					junk  bool
+				}{
+					{c.StringSlice("good"), false},
+					{c.StringSlice("junk"), true},
+				}
+				total := 0
+				for _, corpus := range corpora {
+					for _, path := range corpus.paths {
+						n, err := sisyphus.LearnCorpus(db, path, corpus.junk, progress(path))
+						if err != nil {
+							log.WithFields(log.Fields{
+								"err":    err,
+								"corpus": path,
+							}).Fatal("Cannot learn corpus")
+						}
+						total += n
+					}
+				}
 
-// loadConfig checks the validity of the environment variables and
-// loads the maildirs
-func loadConfig() []sisyphus.Maildir {
+				log.WithFields(log.Fields{
+					"maildir": string(maildir),
+					"mails":   total,
+				}).Info("All corpora learned")
+			},
+		},
+		{
+			Name:      "classify-mbox",
+			Usage:     "split an mbox file into good and junk mails",
+			ArgsUsage: "MBOX",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database classifies the mails",
+				},
+				cli.StringFlag{
+					Name:  "good",
+					Usage: "mbox file the good mails are written to",
+				},
+				cli.StringFlag{
+					Name:  "junk",
+					Usage: "mbox file the junk mails are written to",
+				},
+			},
+			Action: func(c *cli.Context) {
 
-	dirsRaw, ok := os.LookupEnv("SISYPHUS_DIRS")
-	if !ok {
-		log.Fatal("Environment variable SISYPHUS_DIRS not set.")
-	}
-	dirsSplit := strings.Split(dirsRaw, ",")
+				if c.NArg() != 1 || c.String("good") == "" || c.String("junk") == "" {
+					log.Fatal("Please give an mbox file and the outputs with --good and --junk.")
+				}
 
-	var maildirs []sisyphus.Maildir
-	for i := 0; i < len(dirsSplit); i++ {
-		maildirs = append(maildirs, sisyphus.Maildir(dirsSplit[i]))
-	}
+				maildir := loadMaildir(c)
 
-	// Create missing Maildirs
-	err := sisyphus.LoadMaildirs(maildirs)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"err": err,
-		}).Fatal("Cannot load maildirs")
+				db, err := sisyphus.OpenDatabaseReadOnly(string(maildir), lockTimeout)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot open database")
+				}
+				defer db.Close()
+
+				shared := loadShared(map[sisyphus.Maildir]*bolt.DB{maildir: db})
+				if shared != nil {
+					defer shared.DB.Close()
+				}
+
+				in, err := os.Open(c.Args().First())
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot open mbox")
+				}
+				defer in.Close()
+
+				outputs := make(map[string]*os.File)
+				for _, name := range []string{"good", "junk"} {
+					f, err := os.OpenFile(c.String(name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err":  err,
+							"mbox": c.String(name),
+						}).Fatal("Cannot open output")
+					}
+					defer f.Close()
+					outputs[name] = f
+				}
+
+				good, junk, err := maildir.ClassifyMbox(db, shared, in, outputs["good"], outputs["junk"])
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot classify mbox")
+				}
+
+				log.WithFields(log.Fields{
+					"good": good,
+					"junk": junk,
+				}).Info("Mbox classified")
+			},
+		},
+		{
+			Name:  "expire",
+			Usage: "drop the months of learned mails beyond a retention",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database is cleaned up",
+				},
+				cli.DurationFlag{
+					Name:  "retention",
+					Usage: "drop months ended longer ago than this, e.g. 17520h; defaults to SISYPHUS_RETENTION",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+
+				keep := retention()
+				if c.IsSet("retention") {
+					keep = c.Duration("retention")
+				}
+				if keep <= 0 {
+					log.Fatal("Please give a retention with --retention or SISYPHUS_RETENTION.")
+				}
+				before := time.Now().Add(-keep)
+
+				dropped, err := maildir.LiveExpire(before)
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, use the database directly
+					var db *bolt.DB
+					db, err = sisyphus.OpenDatabase(string(maildir))
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot open database")
+					}
+					dropped, err = sisyphus.Expire(db, before)
+					db.Close()
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot expire periods")
+				}
+
+				for _, p := range dropped {
+					fmt.Println(p)
+				}
+			},
+		},
+		{
+			Name:  "compact",
+			Usage: "rewrite the database to give free pages back to the file system",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database is compacted",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+
+				compaction, err := maildir.LiveCompact()
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, use the database directly
+					compaction, err = sisyphus.CompactDatabase(string(maildir))
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot compact database")
+				}
+
+				fmt.Printf("%d -> %d bytes\n", compaction.Before, compaction.After)
+			},
+		},
+		{
+			Name:  "fsck",
+			Usage: "check the database for corrupt entries and repair them",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database is checked",
+				},
+				cli.BoolFlag{
+					Name:  "repair, r",
+					Usage: "delete corrupt entries and create missing buckets",
+				},
+				cli.BoolFlag{
+					Name:  "rebuild-statistics",
+					Usage: "count the mails learned anew from the maildir's contents",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+				request := sisyphus.FsckRequest{
+					Repair:            c.Bool("repair"),
+					RebuildStatistics: c.Bool("rebuild-statistics"),
+					Policies:          flagPolicies(),
+				}
+
+				report, err := maildir.LiveFsck(request)
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, use the database directly
+					var db *bolt.DB
+					db, err = sisyphus.OpenDatabase(string(maildir))
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot open database")
+					}
+					report, err = maildir.Fsck(db, request)
+					db.Close()
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot check database")
+				}
+
+				unrepaired := 0
+				for _, p := range report.Problems {
+					fmt.Println(p)
+					if !p.Repaired {
+						unrepaired++
+					}
+				}
+				if request.RebuildStatistics {
+					fmt.Printf("statistics rebuilt from %d good and %d junk mails\n", report.GoodMails, report.JunkMails)
+				}
+				if unrepaired > 0 {
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Name:  "rebuild",
+			Usage: "retrain the database from scratch and swap it in for the live one",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database is rebuilt",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+
+				n, err := maildir.Rebuild(flagPolicies(), progress(string(maildir)))
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot rebuild database")
+				}
+
+				err = maildir.LiveSwapRebuild()
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, use the database directly
+					var db *bolt.DB
+					db, err = sisyphus.OpenDatabase(string(maildir))
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot open database")
+					}
+					h := sisyphus.NewHandle(db)
+					err = maildir.SwapRebuild(h)
+					h.Close()
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot swap in rebuilt database")
+				}
+
+				fmt.Printf("%d mails learned\n", n)
+			},
+		},
+		{
+			Name:      "explain",
+			Usage:     "show how the words of a mail weigh in its classification",
+			ArgsUsage: "KEY",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir holding the mail",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+				key := c.Args().First()
+				if key == "" {
+					log.Fatal("Please give the key of a mail.")
+				}
+
+				tokens, err := maildir.LiveExplain(key)
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, use the database directly
+					var db *bolt.DB
+					db, err = sisyphus.OpenDatabaseReadOnly(string(maildir), time.Second)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot open database")
+					}
+					tokens, err = maildir.Explain(db, key)
+					db.Close()
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot explain mail")
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "Word\tGood\tJunk\tJunk probability")
+				for _, t := range tokens {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\n", t.Word, t.Good, t.Junk, t.Score)
+				}
+				w.Flush()
+			},
+		},
+		{
+			Name:      "rescue",
+			Usage:     "move mails wrongly classified as junk back to the inbox and learn them as good",
+			ArgsUsage: "[KEY]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir to rescue mails in",
+				},
+				cli.StringFlag{
+					Name:  "message-id",
+					Usage: "rescue the mail with this Message-ID",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "rescue mails from senders containing this text",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "rescue mails moved since a time (RFC 3339) or duration ago, e.g. 24h",
+				},
+				cli.IntFlag{
+					Name:  "last",
+					Usage: "rescue only the last N mails moved to junk",
+				},
+				cli.BoolFlag{
+					Name:  "keep-flags",
+					Usage: "move mails to cur keeping their flags, instead of to new",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+
+				request := sisyphus.RescueRequest{
+					Key: c.Args().First(),
+					Filter: sisyphus.HistoryFilter{
+						Since:     parseTime(c.String("since")),
+						From:      c.String("from"),
+						MessageID: c.String("message-id"),
+					},
+					Last:      c.Int("last"),
+					KeepFlags: c.Bool("keep-flags"),
+				}
+				if request.Key == "" && request.Filter == (sisyphus.HistoryFilter{}) && request.Last == 0 {
+					log.Fatal("Please give a key, --message-id, --from, --since or --last.")
+				}
+
+				rescued, err := maildir.LiveRescue(request)
+				if err == sisyphus.ErrNoDaemon {
+					// No daemon running, use the database directly
+					var db *bolt.DB
+					db, err = sisyphus.OpenDatabase(string(maildir))
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot open database")
+					}
+					rescued, err = maildir.Rescue(db, request)
+					db.Close()
+				}
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+					}).Fatal("Cannot rescue mails")
+				}
+
+				for _, key := range rescued {
+					fmt.Println(key)
+				}
+			},
+		},
+		{
+			Name:      "restore",
+			Usage:     "replace a database by one of its backups",
+			ArgsUsage: "[BACKUP]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "maildir, m",
+					Usage: "maildir whose database is restored",
+				},
+				cli.BoolFlag{
+					Name:  "list, l",
+					Usage: "list the available backups, newest first",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				maildir := loadMaildir(c)
+
+				if c.Bool("list") {
+					backups, err := maildir.Backups()
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+						}).Fatal("Cannot list backups")
+					}
+					for _, b := range backups {
+						fmt.Println(b)
+					}
+					return
+				}
+
+				restored, err := maildir.Restore(c.Args().First())
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err":    err,
+						"backup": restored,
+					}).Fatal("Cannot restore database")
+				}
+
+				log.WithFields(log.Fields{
+					"maildir": string(maildir),
+					"backup":  restored,
+				}).Info("Database restored")
+			},
+		},
+		{
+			Name:      "merge",
+			Usage:     "merge the models of several databases into one",
+			ArgsUsage: "SOURCE...",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "into",
+					Usage: "maildir or database file to merge into",
+				},
+			},
+			Action: func(c *cli.Context) {
+
+				if !c.IsSet("into") || c.NArg() == 0 {
+					log.Fatal("Please give a destination with --into and at least one source.")
+				}
+
+				dst, err := sisyphus.OpenDatabase(c.String("into"))
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err": err,
+						"db":  c.String("into"),
+					}).Fatal("Cannot load database")
+				}
+				defer dst.Close()
+
+				for _, path := range c.Args() {
+					src, err := sisyphus.OpenDatabaseReadOnly(path, lockTimeout)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+							"db":  path,
+						}).Fatal("Cannot load database")
+					}
+
+					err = sisyphus.Merge(dst, src)
+					src.Close()
+					if err != nil {
+						log.WithFields(log.Fields{
+							"err": err,
+							"db":  path,
+						}).Fatal("Cannot merge database")
+					}
+
+					log.WithFields(log.Fields{
+						"db": path,
+					}).Info("Database merged")
+				}
+			},
+		},
+	}
+
+	app.Run(os.Args)
+}
+
+// learn invokes the learning process for a slice of maildirs
+func learn(maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	policies := flagPolicies()
+
+	allowlistAge, err := time.ParseDuration(os.Getenv("SISYPHUS_ALLOWLIST_AGE"))
+	if err != nil {
+		log.Fatal("Cannot parse age of the automatic allowlist.")
+	}
+
+	mails, err := sisyphus.LoadMails(maildirs)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Cannot load mails")
+	}
+	for _, d := range maildirs {
+		h := handles[d]
+		m := mails[d]
+		for _, val := range m {
+			if !policies.Apply(val) {
+				continue
+			}
+			err := h.Use(func(db *bolt.DB) error {
+				return val.Learn(db, d)
+			})
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err":  err,
+					"mail": val.Key,
+				}).Warning("Cannot learn mail")
+			}
+		}
+
+		err := h.Use(func(db *bolt.DB) error {
+			_, err := d.LearnSenders(db)
+			if err != nil {
+				return err
+			}
+
+			_, err = sisyphus.PruneAllowlist(db, time.Now().Add(-allowlistAge))
+			return err
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Warning("Cannot learn senders")
+		}
+	}
+	log.Info("All mails learned")
+
+	return
+}
+
+// flagPolicies returns the flag policies configured in SISYPHUS_FLAGS, or
+// the default ones
+func flagPolicies() sisyphus.FlagPolicies {
+	v, ok := os.LookupEnv("SISYPHUS_FLAGS")
+	if !ok {
+		return sisyphus.DefaultFlagPolicies
+	}
+
+	policies, err := sisyphus.ParseFlagPolicies(v)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Cannot parse flag policies")
+	}
+
+	return policies
+}
+
+// backup creates a verified, timestamped backup copy of the existing
+// databases and removes old backups beyond the configured retention
+func backup(maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	keep, err := strconv.Atoi(os.Getenv("SISYPHUS_BACKUPS"))
+	if err != nil {
+		log.Fatal("Cannot parse number of backups to keep.")
+	}
+
+	for _, d := range maildirs {
+		err := handles[d].Use(func(db *bolt.DB) error {
+			_, err := d.Backup(db, keep)
+			return err
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Error("Backup creation")
+		}
+	}
+
+	log.Info("All databases backed up.")
+
+	return
+}
+
+// pruneHistory removes classification decisions older than the configured
+// retention from the history of all maildirs
+func pruneHistory(maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	retention, err := time.ParseDuration(os.Getenv("SISYPHUS_HISTORY"))
+	if err != nil {
+		log.Fatal("Cannot parse retention of the history.")
+	}
+
+	for _, d := range maildirs {
+		var n int
+		err := handles[d].Use(func(db *bolt.DB) (err error) {
+			n, err = sisyphus.PruneHistory(db, time.Now().Add(-retention))
+			return err
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Error("Cannot prune history")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"maildir": string(d),
+			"removed": n,
+		}).Info("History pruned")
+	}
+
+	return
+}
+
+// parseTime reads a time given either in RFC 3339 format or as a duration
+// before now. An empty string yields the zero time.
+func parseTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d)
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"time": s,
+		}).Fatal("Cannot parse time, use RFC 3339 or a duration like 24h.")
+	}
+
+	return t
+}
+
+// offlineHistory reads the history of a maildir whose database is not held
+// by a running daemon.
+func offlineHistory(d sisyphus.Maildir, f sisyphus.HistoryFilter) (entries []sisyphus.HistoryEntry, err error) {
+	db, err := sisyphus.OpenDatabaseReadOnly(string(d), lockTimeout)
+	if err != nil {
+		return entries, err
+	}
+	defer db.Close()
+
+	return sisyphus.History(db, f)
+}
+
+// serveMetrics exports metrics for Prometheus on addr in the background
+func serveMetrics(addr string, maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	for _, d := range maildirs {
+		err := d.RegisterMetrics(handles[d])
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Error("Cannot register metrics")
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		log.WithFields(log.Fields{
+			"err":  err,
+			"addr": addr,
+		}).Error("Cannot serve metrics")
+	}()
+
+	log.WithFields(log.Fields{
+		"addr": addr,
+	}).Info("Serving metrics")
+}
+
+// offlineStats reads the statistics of a maildir whose database is not held
+// by a running daemon.
+func offlineStats(d sisyphus.Maildir) (s sisyphus.Statistics, err error) {
+	db, err := sisyphus.OpenDatabaseReadOnly(string(d), lockTimeout)
+	if err != nil {
+		return s, err
+	}
+	defer db.Close()
+
+	return d.Stats(db)
+}
+
+// printStats writes the statistics of a maildir as a human readable table
+func printStats(out io.Writer, d sisyphus.Maildir, s sisyphus.Statistics) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	tokens := func(list []sisyphus.Token) string {
+		var t []string
+		for _, val := range list {
+			t = append(t, fmt.Sprintf("%s (%.2f)", val.Word, val.Score))
+		}
+		return strings.Join(t, ", ")
+	}
+
+	lastLearned := "never"
+	if !s.LastLearned.IsZero() {
+		lastLearned = s.LastLearned.Local().Format(time.RFC1123)
+	}
+
+	fmt.Fprintf(w, "Maildir\t%s\n", string(d))
+	fmt.Fprintf(w, "Good mails learned\t%d\n", s.GoodMails)
+	fmt.Fprintf(w, "Junk mails learned\t%d\n", s.JunkMails)
+	fmt.Fprintf(w, "Good words (hapaxes)\t%d (%d)\n", s.GoodWords, s.GoodHapaxes)
+	fmt.Fprintf(w, "Junk words (hapaxes)\t%d (%d)\n", s.JunkWords, s.JunkHapaxes)
+	fmt.Fprintf(w, "Words pruned\t%d\n", s.PrunedWords)
+	fmt.Fprintf(w, "Most good words\t%s\n", tokens(s.TopGood))
+	fmt.Fprintf(w, "Most junk words\t%s\n", tokens(s.TopJunk))
+	fmt.Fprintf(w, "Database size\t%d bytes\n", s.Size)
+	fmt.Fprintf(w, "Last learned\t%s\n", lastLearned)
+	for i, n := range s.Scores {
+		fmt.Fprintf(w, "Junk probability %.1f-%.1f\t%d mails\n", float64(i)/10, float64(i+1)/10, n)
+	}
+	for folder, n := range s.Folders {
+		fmt.Fprintf(w, "Folder %s\t%d mails\n", folder, n)
+	}
+	fmt.Fprintln(w)
+
+	return w.Flush()
+}
+
+// loadShared opens the shared database configured in SISYPHUS_SHARED_DB, if
+// any. It is opened read-only so several daemons can consult it at once. It
+// refuses a shared database that splits mails into other words than the
+// databases of the Maildirs.
+func loadShared(dbs map[sisyphus.Maildir]*bolt.DB) *sisyphus.Shared {
+
+	path, ok := os.LookupEnv("SISYPHUS_SHARED_DB")
+	if !ok {
+		return nil
+	}
+
+	weight := 1.0
+	if w, ok := os.LookupEnv("SISYPHUS_SHARED_WEIGHT"); ok {
+		var err error
+		weight, err = strconv.ParseFloat(w, 64)
+		if err != nil {
+			log.Fatal("Cannot parse weight of the shared database.")
+		}
+	}
+
+	db, err := sisyphus.OpenDatabaseReadOnly(path, lockTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"db":  path,
+		}).Fatal("Cannot load shared database")
+	}
+
+	shared := &sisyphus.Shared{
+		DB:     db,
+		Weight: weight,
+	}
+	for d, mdb := range dbs {
+		err = shared.Check(mdb)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"db":      path,
+				"maildir": string(d),
+			}).Fatal("Cannot use shared database")
+		}
+	}
+
+	return shared
+}
+
+// loadMaildir returns the maildir given by the --maildir flag or, if the flag
+// is not set, the only maildir configured in SISYPHUS_DIRS.
+func loadMaildir(c *cli.Context) sisyphus.Maildir {
+
+	if c.IsSet("maildir") {
+		maildir := sisyphus.Maildir(c.String("maildir"))
+
+		err := sisyphus.LoadMaildirs([]sisyphus.Maildir{maildir})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("Cannot load maildirs")
+		}
+
+		return maildir
+	}
+
+	maildirs := loadConfig()
+	if len(maildirs) != 1 {
+		log.Fatal("Several maildirs configured, please choose one with --maildir.")
+	}
+
+	return maildirs[0]
+}
+
+// progress returns a function logging the progress of learning a corpus, at
+// most once per second
+func progress(path string) sisyphus.Progress {
+	var last time.Time
+
+	return func(mails int, done float64) {
+		if time.Since(last) < time.Second && done < 1 {
+			return
+		}
+		last = time.Now()
+
+		log.WithFields(log.Fields{
+			"corpus": path,
+			"mails":  mails,
+		}).Infof("Learning %.0f%%", done*100)
+	}
+}
+
+// loadLayout configures the Maildir++ folders to use from the environment
+func loadLayout() error {
+	l := sisyphus.DefaultLayout
+	if v, ok := os.LookupEnv("SISYPHUS_JUNK_FOLDER"); ok {
+		l.Junk = v
+	}
+	if v, ok := os.LookupEnv("SISYPHUS_SENT_FOLDER"); ok {
+		l.Sent = v
+	}
+	l.Good = splitList(os.Getenv("SISYPHUS_GOOD_FOLDERS"))
+	l.JunkSources = splitList(os.Getenv("SISYPHUS_JUNK_FOLDERS"))
+	l.Exclude = splitList(os.Getenv("SISYPHUS_EXCLUDE_FOLDERS"))
+
+	return sisyphus.SetLayout(l)
+}
+
+// loadKeys sets the encryption keys configured in SISYPHUS_KEY_FILE or
+// SISYPHUS_KEY, if any
+func loadKeys() error {
+	v, ok := os.LookupEnv("SISYPHUS_KEY")
+	if path, file := os.LookupEnv("SISYPHUS_KEY_FILE"); file {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		v, ok = string(raw), true
+	}
+	if !ok {
+		return nil
+	}
+
+	keys, err := sisyphus.ParseKeys(v)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no encryption key given")
+	}
+
+	return sisyphus.SetEncryptionKeys(keys)
+}
+
+// loadTokenSecret sets the secret words are hashed with, configured in
+// SISYPHUS_TOKEN_SECRET_FILE or SISYPHUS_TOKEN_SECRET, if any
+func loadTokenSecret() error {
+	v, ok := os.LookupEnv("SISYPHUS_TOKEN_SECRET")
+	if path, file := os.LookupEnv("SISYPHUS_TOKEN_SECRET_FILE"); file {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		v, ok = string(raw), true
+	}
+	if !ok {
+		return nil
+	}
+
+	secrets, err := sisyphus.ParseKeys(v)
+	if err != nil {
+		return err
+	}
+	if len(secrets) != 1 {
+		return errors.New("exactly one token secret must be given")
+	}
+
+	return sisyphus.SetTokenSecret(secrets[0])
+}
+
+// loadTokenizer configures the features learned from SISYPHUS_FEATURES and
+// the language words are reduced in from SISYPHUS_LANGUAGE. A value alone
+// applies to all maildirs, MAILDIR=value to one of them.
+func loadTokenizer() error {
+	for env, set := range map[string]func(sisyphus.Maildir, string) error{
+		"SISYPHUS_FEATURES": sisyphus.SetFeatures,
+		"SISYPHUS_LANGUAGE": sisyphus.SetLanguage,
+	} {
+		for _, v := range splitList(os.Getenv(env)) {
+			var d sisyphus.Maildir
+			if i := strings.LastIndex(v, "="); i >= 0 {
+				d, v = sisyphus.Maildir(strings.TrimSpace(v[:i])), strings.TrimSpace(v[i+1:])
+			}
+
+			err := set(d, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadDecay configures the time-decayed model from the environment
+func loadDecay() (err error) {
+	var d sisyphus.Decay
+	if v, ok := os.LookupEnv("SISYPHUS_HALF_LIFE"); ok {
+		d.HalfLife, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+	if v, ok := os.LookupEnv("SISYPHUS_RETENTION"); ok {
+		d.Retention, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return sisyphus.SetDecay(d)
+}
+
+// expire drops the periods beyond the configured retention from the
+// databases, if a retention is configured
+func expire(maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	retention := retention()
+	if retention == 0 {
+		return
+	}
+
+	for _, d := range maildirs {
+		err := handles[d].Use(func(db *bolt.DB) error {
+			_, err := sisyphus.Expire(db, time.Now().Add(-retention))
+			return err
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Error("Cannot expire periods")
+		}
+	}
+}
+
+// pruneTokens drops words from all databases as configured in
+// SISYPHUS_PRUNE_HAPAXES, SISYPHUS_PRUNE_KEEP and SISYPHUS_PRUNE_SIZE. A
+// database larger than configured is compacted after words were dropped.
+func pruneTokens(maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	var p sisyphus.Pruning
+	var err error
+
+	if v, ok := os.LookupEnv("SISYPHUS_PRUNE_HAPAXES"); ok {
+		p.HapaxAge, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Cannot parse the age of hapaxes to prune.")
+		}
+	}
+	if v, ok := os.LookupEnv("SISYPHUS_PRUNE_KEEP"); ok {
+		p.Keep, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("Cannot parse the number of words to keep.")
+		}
+	}
+	if v, ok := os.LookupEnv("SISYPHUS_PRUNE_SIZE"); ok {
+		p.MaxSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal("Cannot parse the maximum database size.")
+		}
+	}
+	if p == (sisyphus.Pruning{}) {
+		return
+	}
+
+	for _, d := range maildirs {
+		var r sisyphus.PruneReport
+		err := handles[d].Use(func(db *bolt.DB) (err error) {
+			r, err = d.PruneTokens(db, p, time.Now())
+			return err
+		})
+		if err == nil && p.MaxSize > 0 && r.Uninformative > 0 {
+			_, err = handles[d].Compact()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Error("Cannot prune words")
+		}
+	}
+}
+
+// compact compacts the databases whose files consist of free pages to at
+// least the fraction configured in SISYPHUS_COMPACT, if it is set
+func compact(maildirs []sisyphus.Maildir, handles map[sisyphus.Maildir]*sisyphus.Handle) {
+	v, ok := os.LookupEnv("SISYPHUS_COMPACT")
+	if !ok {
+		return
+	}
+	threshold, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatal("Cannot parse the fraction of free pages to compact at.")
+	}
+
+	for _, d := range maildirs {
+		free, err := handles[d].Free()
+		if err == nil && free >= threshold {
+			_, err = handles[d].Compact()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"maildir": string(d),
+			}).Error("Cannot compact database")
+		}
+	}
+}
+
+// retention returns how long periods are kept, or zero to keep them forever
+func retention() time.Duration {
+	v, ok := os.LookupEnv("SISYPHUS_RETENTION")
+	if !ok {
+		return 0
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatal("Cannot parse retention of periods.")
+	}
+
+	return d
+}
+
+// splitList splits a comma-separated list, dropping empty elements
+func splitList(s string) (l []string) {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+// loadConfig checks the validity of the environment variables and
+// loads the maildirs
+func loadConfig() []sisyphus.Maildir {
+
+	dirsRaw, ok := os.LookupEnv("SISYPHUS_DIRS")
+	if !ok {
+		log.Fatal("Environment variable SISYPHUS_DIRS not set.")
+	}
+	dirsSplit := strings.Split(dirsRaw, ",")
+
+	var maildirs []sisyphus.Maildir
+	for i := 0; i < len(dirsSplit); i++ {
+		maildirs = append(maildirs, sisyphus.Maildir(dirsSplit[i]))
+	}
+
+	// Create missing Maildirs
+	err := sisyphus.LoadMaildirs(maildirs)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Cannot load maildirs")
+	}
+
+	// Check backup configuration and set it to default value if not set
+	_, ok = os.LookupEnv("SISYPHUS_BACKUPS")
+	if !ok {
+		os.Setenv("SISYPHUS_BACKUPS", "3")
+	}
+
+	// Check history retention and set it to default value if not set
+	_, ok = os.LookupEnv("SISYPHUS_HISTORY")
+	if !ok {
+		os.Setenv("SISYPHUS_HISTORY", "2160h")
+	}
+
+	// Check allowlist retention and set it to default value if not set
+	_, ok = os.LookupEnv("SISYPHUS_ALLOWLIST_AGE")
+	if !ok {
+		os.Setenv("SISYPHUS_ALLOWLIST_AGE", "8760h")
 	}
 
 	// Check duration configuration and set it to default value if
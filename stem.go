@@ -0,0 +1,897 @@
+package sisyphus
+
+import (
+	"bytes"
+	"strings"
+)
+
+// The stemmers follow the Snowball algorithms of their languages. Mails
+// are stripped of their accents before they are split into words, so the
+// stemmers see and produce words of the letters a to z only: rules for
+// accented letters apply to their base letters instead. Upper case letters
+// mark vowels to be treated as consonants while a word is stemmed.
+
+// stemming is a word being reduced to its stem, with the start of its
+// regions. R1 is the region after the first non-vowel following a vowel, R2
+// the region after the first non-vowel following a vowel in R1, and RV a
+// region depending on the language.
+type stemming struct {
+	b          []byte
+	r1, r2, rv int
+}
+
+// region returns the start of the region after the first non-vowel
+// following a vowel after from, or the end of the word if there is none
+func region(b []byte, vowel func(byte) bool, from int) int {
+	for i := from + 1; i < len(b); i++ {
+		if vowel(b[i-1]) && !vowel(b[i]) {
+			return i + 1
+		}
+	}
+
+	return len(b)
+}
+
+// romanceRV returns the start of RV of Italian and Spanish words: after the
+// next vowel if the second letter is a consonant, after the next consonant
+// if the first two letters are vowels, and after the third letter otherwise
+func romanceRV(b []byte, vowel func(byte) bool) int {
+	if len(b) < 3 {
+		return len(b)
+	}
+
+	switch {
+	case !vowel(b[1]):
+		for i := 2; i < len(b); i++ {
+			if vowel(b[i]) {
+				return i + 1
+			}
+		}
+		return len(b)
+	case vowel(b[0]):
+		for i := 2; i < len(b); i++ {
+			if !vowel(b[i]) {
+				return i + 1
+			}
+		}
+		return len(b)
+	}
+
+	return 3
+}
+
+// ends tells whether the word ends with suffix
+func (s *stemming) ends(suffix string) bool {
+	return bytes.HasSuffix(s.b, []byte(suffix))
+}
+
+// endsIn tells whether the word ends with suffix starting in the region
+// starting at r
+func (s *stemming) endsIn(r int, suffix string) bool {
+	return s.ends(suffix) && len(s.b)-len(suffix) >= r
+}
+
+// longest returns the longest of the suffixes the word ends with, or an
+// empty string if there is none
+func (s *stemming) longest(suffixes []string) (found string) {
+	for _, suffix := range suffixes {
+		if len(suffix) > len(found) && s.ends(suffix) {
+			found = suffix
+		}
+	}
+
+	return found
+}
+
+// longestIn returns the longest of the suffixes the word ends with in the
+// region starting at r, or an empty string if there is none
+func (s *stemming) longestIn(r int, suffixes []string) (found string) {
+	for _, suffix := range suffixes {
+		if len(suffix) > len(found) && s.endsIn(r, suffix) {
+			found = suffix
+		}
+	}
+
+	return found
+}
+
+// replace replaces the suffix the word ends with by another
+func (s *stemming) replace(suffix, by string) {
+	s.b = append(s.b[:len(s.b)-len(suffix)], by...)
+}
+
+// cutIn removes the suffix if the word ends with it in the region starting
+// at r, and tells whether it did
+func (s *stemming) cutIn(r int, suffix string) bool {
+	if !s.endsIn(r, suffix) {
+		return false
+	}
+	s.replace(suffix, "")
+
+	return true
+}
+
+// before returns the letter before the suffix, or zero if there is none
+func (s *stemming) before(suffix string) byte {
+	i := len(s.b) - len(suffix) - 1
+	if i < 0 {
+		return 0
+	}
+
+	return s.b[i]
+}
+
+// suffixes returns the space separated suffixes of a list
+func suffixes(list string) []string {
+	return strings.Fields(list)
+}
+
+// englishVowel tells whether a letter is an English vowel
+func englishVowel(c byte) bool {
+	return strings.IndexByte("aeiouy", c) >= 0
+}
+
+// englishExceptions are English words stemmed irregularly
+var englishExceptions = map[string]string{
+	"skies": "sky", "dying": "die", "lying": "lie", "tying": "tie",
+	"idly": "idl", "gently": "gentl", "ugly": "ugli", "early": "earli",
+	"only": "onli", "singly": "singl", "sky": "sky", "news": "news",
+	"howe": "howe", "atlas": "atlas", "cosmos": "cosmos", "bias": "bias",
+	"andes": "andes",
+}
+
+// englishInvariants are English words kept as they are once their plural
+// has been removed
+var englishInvariants = wordSet("inning outing canning herring earring proceed exceed succeed")
+
+// englishShort tells whether b ends with a short syllable: a vowel followed
+// by a non-vowel other than w, x or Y and preceded by a non-vowel, or a
+// vowel at the beginning of the word followed by a non-vowel
+func englishShort(b []byte) bool {
+	n := len(b)
+	if n == 2 {
+		return englishVowel(b[0]) && !englishVowel(b[1])
+	}
+
+	return n > 2 && !englishVowel(b[n-3]) && englishVowel(b[n-2]) &&
+		!englishVowel(b[n-1]) && strings.IndexByte("wxY", b[n-1]) < 0
+}
+
+var (
+	englishStep1a = suffixes("sses ied ies us ss s")
+	englishStep1b = suffixes("eed eedly ed edly ing ingly")
+	englishStep2  = map[string]string{
+		"tional": "tion", "enci": "ence", "anci": "ance", "abli": "able",
+		"entli": "ent", "izer": "ize", "ization": "ize", "ational": "ate",
+		"ation": "ate", "ator": "ate", "alism": "al", "aliti": "al",
+		"alli": "al", "fulness": "ful", "ousli": "ous", "ousness": "ous",
+		"iveness": "ive", "iviti": "ive", "biliti": "ble", "bli": "ble",
+		"ogi": "og", "fulli": "ful", "lessli": "less", "li": "",
+	}
+	englishStep3 = map[string]string{
+		"tional": "tion", "ational": "ate", "alize": "al", "icate": "ic",
+		"iciti": "ic", "ical": "ic", "ful": "", "ness": "", "ative": "",
+	}
+	englishStep2Suffixes = suffixKeys(englishStep2)
+	englishStep3Suffixes = suffixKeys(englishStep3)
+	englishStep4         = suffixes("al ance ence er ic able ible ant ement ment ent ism ate iti ous ive ize ion")
+)
+
+// suffixKeys returns the suffixes of a map of replacements
+func suffixKeys(m map[string]string) (l []string) {
+	for k := range m {
+		l = append(l, k)
+	}
+
+	return l
+}
+
+// stemEnglish reduces an English word to its stem following the Porter2
+// algorithm
+func stemEnglish(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	if stem, ok := englishExceptions[word]; ok {
+		return stem
+	}
+
+	s := &stemming{b: []byte(word)}
+	for i := range s.b {
+		if s.b[i] == 'y' && (i == 0 || englishVowel(s.b[i-1])) {
+			s.b[i] = 'Y'
+		}
+	}
+
+	s.r1 = region(s.b, englishVowel, 0)
+	for _, prefix := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(word, prefix) {
+			s.r1 = len(prefix)
+		}
+	}
+	s.r2 = region(s.b, englishVowel, s.r1)
+
+	// step 1a: plurals
+	switch suffix := s.longest(englishStep1a); suffix {
+	case "sses":
+		s.replace(suffix, "ss")
+	case "ied", "ies":
+		if len(s.b) > 4 {
+			s.replace(suffix, "i")
+		} else {
+			s.replace(suffix, "ie")
+		}
+	case "s":
+		if bytes.IndexFunc(s.b[:len(s.b)-2], func(r rune) bool { return englishVowel(byte(r)) }) >= 0 {
+			s.replace(suffix, "")
+		}
+	}
+
+	if englishInvariants[string(s.b)] {
+		return string(s.b)
+	}
+
+	// step 1b: past tenses and gerunds
+	switch suffix := s.longest(englishStep1b); suffix {
+	case "eed", "eedly":
+		if s.endsIn(s.r1, suffix) {
+			s.replace(suffix, "ee")
+		}
+	case "ed", "edly", "ing", "ingly":
+		stem := s.b[:len(s.b)-len(suffix)]
+		if bytes.IndexFunc(stem, func(r rune) bool { return englishVowel(byte(r)) }) < 0 {
+			break
+		}
+		s.replace(suffix, "")
+
+		n := len(s.b)
+		switch {
+		case s.ends("at") || s.ends("bl") || s.ends("iz"):
+			s.b = append(s.b, 'e')
+		case n > 1 && s.b[n-1] == s.b[n-2] && strings.IndexByte("bdfgmnprt", s.b[n-1]) >= 0:
+			s.b = s.b[:n-1]
+		case englishShort(s.b) && s.r1 >= n:
+			s.b = append(s.b, 'e')
+		}
+	}
+
+	// step 1c: final y
+	n := len(s.b)
+	if n > 2 && (s.b[n-1] == 'y' || s.b[n-1] == 'Y') && !englishVowel(s.b[n-2]) {
+		s.b[n-1] = 'i'
+	}
+
+	// step 2: double suffixes
+	if suffix := s.longest(englishStep2Suffixes); suffix != "" && s.endsIn(s.r1, suffix) {
+		switch {
+		case suffix == "ogi" && s.before(suffix) != 'l':
+		case suffix == "li" && strings.IndexByte("cdeghkmnrt", s.before(suffix)) < 0:
+		default:
+			s.replace(suffix, englishStep2[suffix])
+		}
+	}
+
+	// step 3: derivational suffixes
+	if suffix := s.longest(englishStep3Suffixes); suffix != "" && s.endsIn(s.r1, suffix) {
+		if suffix != "ative" || s.endsIn(s.r2, suffix) {
+			s.replace(suffix, englishStep3[suffix])
+		}
+	}
+
+	// step 4: remaining suffixes
+	if suffix := s.longest(englishStep4); suffix != "" && s.endsIn(s.r2, suffix) {
+		if c := s.before(suffix); suffix != "ion" || c == 's' || c == 't' {
+			s.replace(suffix, "")
+		}
+	}
+
+	// step 5: final e and l
+	switch {
+	case s.ends("e"):
+		stem := s.b[:len(s.b)-1]
+		if s.endsIn(s.r2, "e") || s.endsIn(s.r1, "e") && !englishShort(stem) {
+			s.replace("e", "")
+		}
+	case s.ends("ll") && s.endsIn(s.r2, "l"):
+		s.replace("l", "")
+	}
+
+	return strings.Replace(string(s.b), "Y", "y", -1)
+}
+
+// germanVowel tells whether a letter is a German vowel
+func germanVowel(c byte) bool {
+	return strings.IndexByte("aeiouy", c) >= 0
+}
+
+var (
+	germanStep1 = suffixes("em ern er e en es s")
+	germanStep2 = suffixes("en er est st")
+	germanStep3 = suffixes("end ung ig ik isch lich heit keit")
+)
+
+// stemGerman reduces a German word to its stem following the German variant
+// of the Snowball algorithm, which reads ae, oe and ue as umlauts
+func stemGerman(word string) string {
+	s := &stemming{b: []byte(word)}
+	for i := 1; i < len(s.b)-1; i++ {
+		if germanVowel(s.b[i-1]) && germanVowel(s.b[i+1]) {
+			switch s.b[i] {
+			case 'u':
+				s.b[i] = 'U'
+			case 'y':
+				s.b[i] = 'Y'
+			}
+		}
+	}
+	for i := 1; i < len(s.b); i++ {
+		if s.b[i] == 'e' && strings.IndexByte("aou", s.b[i-1]) >= 0 && (i < 2 || s.b[i-2] != 'q') {
+			s.b = append(s.b[:i], s.b[i+1:]...)
+		}
+	}
+
+	s.r1 = region(s.b, germanVowel, 0)
+	if s.r1 < 3 {
+		s.r1 = 3
+	}
+	s.r2 = region(s.b, germanVowel, s.r1)
+
+	// step 1: inflections
+	if suffix := s.longest(germanStep1); suffix != "" && s.endsIn(s.r1, suffix) {
+		switch suffix {
+		case "em", "ern", "er":
+			s.replace(suffix, "")
+		case "e", "en", "es":
+			s.replace(suffix, "")
+			if s.ends("niss") {
+				s.replace("s", "")
+			}
+		case "s":
+			if strings.IndexByte("bdfghklmnrt", s.before(suffix)) >= 0 {
+				s.replace(suffix, "")
+			}
+		}
+	}
+
+	// step 2: comparatives and superlatives
+	if suffix := s.longest(germanStep2); suffix != "" && s.endsIn(s.r1, suffix) {
+		switch suffix {
+		case "en", "er", "est":
+			s.replace(suffix, "")
+		case "st":
+			if strings.IndexByte("bdfghklmnt", s.before(suffix)) >= 0 && len(s.b) > 5 {
+				s.replace(suffix, "")
+			}
+		}
+	}
+
+	// step 3: derivational suffixes
+	if suffix := s.longest(germanStep3); suffix != "" && s.endsIn(s.r2, suffix) {
+		switch suffix {
+		case "end", "ung":
+			s.replace(suffix, "")
+			if s.endsIn(s.r2, "ig") && s.before("ig") != 'e' {
+				s.replace("ig", "")
+			}
+		case "ig", "ik", "isch":
+			if s.before(suffix) != 'e' {
+				s.replace(suffix, "")
+			}
+		case "lich", "heit":
+			s.replace(suffix, "")
+			if !s.cutIn(s.r1, "er") {
+				s.cutIn(s.r1, "en")
+			}
+		case "keit":
+			s.replace(suffix, "")
+			if !s.cutIn(s.r2, "lich") {
+				s.cutIn(s.r2, "ig")
+			}
+		}
+	}
+
+	return strings.ToLower(string(s.b))
+}
+
+// romanceVowel tells whether a letter is a French, Italian or Spanish vowel
+func romanceVowel(c byte) bool {
+	return strings.IndexByte("aeiouy", c) >= 0
+}
+
+var (
+	frenchStep1 = suffixes(`ance iqUe isme able iste eux ances iqUes ismes
+		ables istes atrice ateur ation atrices ateurs ations logie logies
+		usion ution usions utions ence ences ement ements ite ites if ive ifs
+		ives eaux aux euse euses issement issements amment emment ment ments`)
+	frenchStep2a = suffixes(`imes it ites i ie ies ir ira irai iraIent irais
+		irait iras irent irez iriez irions irons iront is issaIent issais
+		issait issant issante issantes issants isse issent isses issez
+		issiez issions issons`)
+	frenchStep2b = suffixes(`ions e ee ees es erent er era erai eraIent erais
+		erait eras erez eriez erions erons eront ez iez ames at ates a ai
+		aIent ais ait ant ante antes ants as asse assent asses assiez
+		assions`)
+	frenchStep4 = suffixes("ion ier iere Ier Iere e")
+)
+
+// stemFrench reduces a French word to its stem following the Snowball
+// algorithm
+func stemFrench(word string) string {
+	s := &stemming{b: []byte(word)}
+	for i := range s.b {
+		prev := i > 0 && romanceVowel(s.b[i-1])
+		next := i < len(s.b)-1 && romanceVowel(s.b[i+1])
+		switch {
+		case s.b[i] == 'u' && i > 0 && s.b[i-1] == 'q':
+			s.b[i] = 'U'
+		case (s.b[i] == 'u' || s.b[i] == 'i') && prev && next:
+			s.b[i] -= 'a' - 'A'
+		case s.b[i] == 'y' && (prev || next):
+			s.b[i] = 'Y'
+		}
+	}
+
+	s.rv = len(s.b)
+	switch {
+	case len(s.b) > 2 && (romanceVowel(s.b[0]) && romanceVowel(s.b[1]) ||
+		strings.HasPrefix(word, "par") || strings.HasPrefix(word, "col") ||
+		strings.HasPrefix(word, "tap")):
+		s.rv = 3
+	default:
+		for i := 1; i < len(s.b); i++ {
+			if romanceVowel(s.b[i]) {
+				s.rv = i + 1
+				break
+			}
+		}
+	}
+	s.r1 = region(s.b, romanceVowel, 0)
+	s.r2 = region(s.b, romanceVowel, s.r1)
+
+	altered, verbs := s.frenchStep1()
+	if !altered && verbs {
+		altered = s.frenchStep2a() || s.frenchStep2b()
+	}
+
+	if altered {
+		if s.ends("Y") {
+			s.replace("Y", "i")
+		}
+	} else {
+		// step 4: residual suffixes
+		if s.ends("s") && strings.IndexByte("aious", s.before("s")) < 0 {
+			s.replace("s", "")
+		}
+		if suffix := s.longestIn(s.rv, frenchStep4); suffix != "" {
+			switch suffix {
+			case "ion":
+				c := s.before(suffix)
+				if s.endsIn(s.r2, suffix) && len(s.b)-4 >= s.rv && (c == 's' || c == 't') {
+					s.replace(suffix, "")
+				}
+			case "e":
+				s.replace(suffix, "")
+			default:
+				s.replace(suffix, "i")
+			}
+		}
+	}
+
+	// step 5: double consonants
+	for _, double := range []string{"enn", "onn", "ett", "ell", "eill"} {
+		if s.ends(double) {
+			s.replace(double[len(double)-1:], "")
+			break
+		}
+	}
+
+	return strings.ToLower(string(s.b))
+}
+
+// frenchStep1 removes a standard suffix of a French word. It tells whether
+// it altered the word and whether verb suffixes are to be removed next.
+func (s *stemming) frenchStep1() (altered, verbs bool) {
+	suffix := s.longest(frenchStep1)
+	switch suffix {
+	case "":
+		return false, true
+	case "ance", "iqUe", "isme", "able", "iste", "eux", "ances", "iqUes",
+		"ismes", "ables", "istes":
+		return s.cutIn(s.r2, suffix), true
+	case "atrice", "ateur", "ation", "atrices", "ateurs", "ations":
+		if !s.cutIn(s.r2, suffix) {
+			return false, true
+		}
+		if !s.cutIn(s.r2, "ic") && s.ends("ic") {
+			s.replace("ic", "iqU")
+		}
+	case "logie", "logies":
+		if !s.endsIn(s.r2, suffix) {
+			return false, true
+		}
+		s.replace(suffix, "log")
+	case "usion", "ution", "usions", "utions":
+		if !s.endsIn(s.r2, suffix) {
+			return false, true
+		}
+		s.replace(suffix, "u")
+	case "ence", "ences":
+		if !s.endsIn(s.r2, suffix) {
+			return false, true
+		}
+		s.replace(suffix, "ent")
+	case "ement", "ements":
+		if !s.cutIn(s.rv, suffix) {
+			return false, true
+		}
+		switch {
+		case s.cutIn(s.r2, "iv"):
+			s.cutIn(s.r2, "at")
+		case s.ends("eus"):
+			if !s.cutIn(s.r2, "eus") && s.endsIn(s.r1, "eus") {
+				s.replace("eus", "eux")
+			}
+		case s.ends("abl"):
+			s.cutIn(s.r2, "abl")
+		case s.ends("iqU"):
+			s.cutIn(s.r2, "iqU")
+		case s.endsIn(s.rv, "ier"):
+			s.replace("ier", "i")
+		case s.endsIn(s.rv, "Ier"):
+			s.replace("Ier", "i")
+		}
+	case "ite", "ites":
+		if !s.cutIn(s.r2, suffix) {
+			return false, true
+		}
+		switch {
+		case s.ends("abil"):
+			if !s.cutIn(s.r2, "abil") {
+				s.replace("abil", "abl")
+			}
+		case s.ends("ic"):
+			if !s.cutIn(s.r2, "ic") {
+				s.replace("ic", "iqU")
+			}
+		default:
+			s.cutIn(s.r2, "iv")
+		}
+	case "if", "ive", "ifs", "ives":
+		if !s.cutIn(s.r2, suffix) {
+			return false, true
+		}
+		if s.cutIn(s.r2, "at") && s.ends("ic") && !s.cutIn(s.r2, "ic") {
+			s.replace("ic", "iqU")
+		}
+	case "eaux":
+		s.replace(suffix, "eau")
+	case "aux":
+		if !s.endsIn(s.r1, suffix) {
+			return false, true
+		}
+		s.replace(suffix, "al")
+	case "euse", "euses":
+		switch {
+		case s.cutIn(s.r2, suffix):
+		case s.endsIn(s.r1, suffix):
+			s.replace(suffix, "eux")
+		default:
+			return false, true
+		}
+	case "issement", "issements":
+		if !s.endsIn(s.r1, suffix) || romanceVowel(s.before(suffix)) {
+			return false, true
+		}
+		s.replace(suffix, "")
+	case "amment":
+		if s.endsIn(s.rv, suffix) {
+			s.replace(suffix, "ant")
+		}
+		return false, true
+	case "emment":
+		if s.endsIn(s.rv, suffix) {
+			s.replace(suffix, "ent")
+		}
+		return false, true
+	case "ment", "ments":
+		if s.endsIn(s.rv+1, suffix) && romanceVowel(s.before(suffix)) {
+			s.replace(suffix, "")
+		}
+		return false, true
+	}
+
+	return true, false
+}
+
+// frenchStep2a removes a verb suffix beginning with i of a French word
+func (s *stemming) frenchStep2a() bool {
+	suffix := s.longestIn(s.rv, frenchStep2a)
+	if suffix == "" || !s.endsIn(s.rv+1, suffix) || romanceVowel(s.before(suffix)) {
+		return false
+	}
+	s.replace(suffix, "")
+
+	return true
+}
+
+// frenchStep2b removes another verb suffix of a French word
+func (s *stemming) frenchStep2b() bool {
+	suffix := s.longestIn(s.rv, frenchStep2b)
+	if suffix == "" {
+		return false
+	}
+
+	switch suffix {
+	case "ions":
+		return s.cutIn(s.r2, suffix)
+	case "ames", "at", "ates", "a", "ai", "aIent", "ais", "ait", "ant",
+		"ante", "antes", "ants", "as", "asse", "assent", "asses", "assiez",
+		"assions":
+		s.replace(suffix, "")
+		s.cutIn(s.rv, "e")
+	default:
+		s.replace(suffix, "")
+	}
+
+	return true
+}
+
+var (
+	italianPronouns = suffixes(`ci gli la le li lo mi ne si ti vi sene gliela
+		gliele glieli glielo gliene mela mele meli melo mene tela tele teli
+		telo tene cela cele celi celo cene vela vele veli velo vene`)
+	italianStep1 = suffixes(`anza anze ico ici ica ice iche ichi ismo ismi
+		abile abili ibile ibili ista iste isti oso osi osa ose mente atrice
+		atrici ante anti azione azioni atore atori logia logie uzione uzioni
+		usione usioni enza enze amento amenti imento imenti amente ita ivo
+		ivi iva ive`)
+	italianStep2 = suffixes(`ammo ando ano are arono asse assero assi assimo
+		ata ate ati ato ava avamo avano avate avi avo emmo enda ende endi
+		endo era erai eranno ere erebbe erebbero erei eremmo eremo ereste
+		eresti erete ero erono essero ete eva evamo evano evate evi evo iamo
+		immo ira irai iranno ire irebbe irebbero irei iremmo iremo ireste
+		iresti irete iro irono isca iscano isce isci isco iscono issero ita
+		ite iti ito iva ivamo ivano ivate ivi ivo ar ir`)
+)
+
+// stemItalian reduces an Italian word to its stem following the Snowball
+// algorithm
+func stemItalian(word string) string {
+	italianVowel := func(c byte) bool { return c != 'y' && romanceVowel(c) }
+
+	s := &stemming{b: []byte(word)}
+	for i := range s.b {
+		switch {
+		case s.b[i] == 'u' && i > 0 && s.b[i-1] == 'q':
+			s.b[i] = 'U'
+		case (s.b[i] == 'u' || s.b[i] == 'i') && i > 0 && i < len(s.b)-1 &&
+			italianVowel(s.b[i-1]) && italianVowel(s.b[i+1]):
+			s.b[i] -= 'a' - 'A'
+		}
+	}
+
+	s.rv = romanceRV(s.b, italianVowel)
+	s.r1 = region(s.b, italianVowel, 0)
+	s.r2 = region(s.b, italianVowel, s.r1)
+
+	// step 0: attached pronouns
+	if pronoun := s.longest(italianPronouns); pronoun != "" {
+		verb := &stemming{b: s.b[:len(s.b)-len(pronoun)]}
+		switch ending := verb.longest(suffixes("ando endo ar er ir")); {
+		case ending == "" || !verb.endsIn(s.rv, ending):
+		case ending == "ando" || ending == "endo":
+			s.replace(pronoun, "")
+		default:
+			s.replace(pronoun, "e")
+		}
+	}
+
+	// step 1: standard suffixes, or else step 2: verb suffixes
+	if !s.italianStep1() {
+		if suffix := s.longestIn(s.rv, italianStep2); suffix != "" {
+			s.replace(suffix, "")
+		}
+	}
+
+	// step 3: final vowels and ch or gh
+	for _, v := range []string{"a", "e", "i", "o"} {
+		if s.cutIn(s.rv, v) {
+			s.cutIn(s.rv, "i")
+			break
+		}
+	}
+	if s.endsIn(s.rv, "ch") || s.endsIn(s.rv, "gh") {
+		s.replace("h", "")
+	}
+
+	return strings.ToLower(string(s.b))
+}
+
+// italianStep1 removes a standard suffix of an Italian word and tells
+// whether it did
+func (s *stemming) italianStep1() bool {
+	switch suffix := s.longest(italianStep1); suffix {
+	case "":
+		return false
+	case "azione", "azioni", "atore", "atori":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		s.cutIn(s.r2, "ic")
+	case "logia", "logie":
+		if !s.endsIn(s.r2, suffix) {
+			return false
+		}
+		s.replace(suffix, "log")
+	case "uzione", "uzioni", "usione", "usioni":
+		if !s.endsIn(s.r2, suffix) {
+			return false
+		}
+		s.replace(suffix, "u")
+	case "enza", "enze":
+		if !s.endsIn(s.r2, suffix) {
+			return false
+		}
+		s.replace(suffix, "ente")
+	case "amento", "amenti", "imento", "imenti":
+		return s.cutIn(s.rv, suffix)
+	case "amente":
+		if !s.cutIn(s.r1, suffix) {
+			return false
+		}
+		if s.cutIn(s.r2, "iv") {
+			s.cutIn(s.r2, "at")
+		} else if !s.cutIn(s.r2, "os") && !s.cutIn(s.r2, "ic") {
+			s.cutIn(s.r2, "abil")
+		}
+	case "ita":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		if !s.cutIn(s.r2, "abil") && !s.cutIn(s.r2, "ic") {
+			s.cutIn(s.r2, "iv")
+		}
+	case "ivo", "ivi", "iva", "ive":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		if s.cutIn(s.r2, "at") {
+			s.cutIn(s.r2, "ic")
+		}
+	default:
+		return s.cutIn(s.r2, suffix)
+	}
+
+	return true
+}
+
+var (
+	spanishPronouns = suffixes("me se sela selo selas selos la le lo las les los nos")
+	spanishStep1    = suffixes(`anza anzas ico ica icos icas ismo ismos able
+		ables ible ibles ista istas oso osa osos osas amiento amientos imiento
+		imientos adora ador acion adoras adores aciones ante antes ancia
+		ancias logia logias ucion uciones encia encias amente mente idad
+		idades iva ivo ivas ivos`)
+	spanishStep2a = suffixes("ya ye yan yen yeron yendo yo yas yes yais yamos")
+	spanishStep2b = suffixes(`en es eis emos arian arias aran aras ariais aria
+		areis ariamos aremos ara are erian erias eran eras eriais eria ereis
+		eriamos eremos era ere irian irias iran iras iriais iria ireis
+		iriamos iremos ira ire aba ada ida ia iera ad ed id ase iese aste
+		iste an aban ian ieran asen iesen aron ieron ado ido ando iendo io ar
+		er ir as abas adas idas ias aras ieras ases ieses is ais abais iais
+		arais ierais aseis ieseis asteis isteis ados idos amos abamos iamos
+		imos aramos ieramos iesemos asemos`)
+)
+
+// stemSpanish reduces a Spanish word to its stem following the Snowball
+// algorithm
+func stemSpanish(word string) string {
+	s := &stemming{b: []byte(word)}
+
+	spanishVowel := func(c byte) bool { return c != 'y' && romanceVowel(c) }
+	s.rv = romanceRV(s.b, spanishVowel)
+	s.r1 = region(s.b, spanishVowel, 0)
+	s.r2 = region(s.b, spanishVowel, s.r1)
+
+	// step 0: attached pronouns
+	if pronoun := s.longest(spanishPronouns); pronoun != "" {
+		verb := &stemming{b: s.b[:len(s.b)-len(pronoun)]}
+		switch ending := verb.longest(suffixes("ando iendo yendo ar er ir")); {
+		case ending == "" || !verb.endsIn(s.rv, ending):
+		case ending == "yendo" && verb.before(ending) != 'u':
+		default:
+			s.replace(pronoun, "")
+		}
+	}
+
+	// step 1: standard suffixes, or else step 2: verb suffixes
+	if !s.spanishStep1() {
+		if suffix := s.longestIn(s.rv, spanishStep2a); suffix != "" && s.before(suffix) == 'u' {
+			s.replace(suffix, "")
+		} else if suffix := s.longestIn(s.rv, spanishStep2b); suffix != "" {
+			s.replace(suffix, "")
+			switch suffix {
+			case "en", "es", "eis", "emos":
+				if s.ends("gu") {
+					s.replace("u", "")
+				}
+			}
+		}
+	}
+
+	// step 3: residual suffixes
+	switch suffix := s.longestIn(s.rv, suffixes("os a o i e")); suffix {
+	case "e":
+		s.replace(suffix, "")
+		if s.ends("gu") && s.endsIn(s.rv, "u") {
+			s.replace("u", "")
+		}
+	case "":
+	default:
+		s.replace(suffix, "")
+	}
+
+	return string(s.b)
+}
+
+// spanishStep1 removes a standard suffix of a Spanish word and tells
+// whether it did
+func (s *stemming) spanishStep1() bool {
+	switch suffix := s.longest(spanishStep1); suffix {
+	case "":
+		return false
+	case "adora", "ador", "acion", "adoras", "adores", "aciones", "ante",
+		"antes", "ancia", "ancias":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		s.cutIn(s.r2, "ic")
+	case "logia", "logias":
+		if !s.endsIn(s.r2, suffix) {
+			return false
+		}
+		s.replace(suffix, "log")
+	case "ucion", "uciones":
+		if !s.endsIn(s.r2, suffix) {
+			return false
+		}
+		s.replace(suffix, "u")
+	case "encia", "encias":
+		if !s.endsIn(s.r2, suffix) {
+			return false
+		}
+		s.replace(suffix, "ente")
+	case "amente":
+		if !s.cutIn(s.r1, suffix) {
+			return false
+		}
+		if s.cutIn(s.r2, "iv") {
+			s.cutIn(s.r2, "at")
+		} else if !s.cutIn(s.r2, "os") && !s.cutIn(s.r2, "ic") {
+			s.cutIn(s.r2, "ad")
+		}
+	case "mente":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		if !s.cutIn(s.r2, "ante") && !s.cutIn(s.r2, "able") {
+			s.cutIn(s.r2, "ible")
+		}
+	case "idad", "idades":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		if !s.cutIn(s.r2, "abil") && !s.cutIn(s.r2, "ic") {
+			s.cutIn(s.r2, "iv")
+		}
+	case "iva", "ivo", "ivas", "ivos":
+		if !s.cutIn(s.r2, suffix) {
+			return false
+		}
+		s.cutIn(s.r2, "at")
+	default:
+		return s.cutIn(s.r2, suffix)
+	}
+
+	return true
+}
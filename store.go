@@ -0,0 +1,206 @@
+package sisyphus
+
+import (
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store holds a learned model: values, typically marshalled HyperLogLog
+// sketches, by key in buckets named by slash separated paths such as
+// "Wordlists/Good". The classifier reads and writes its model only through a
+// Store, so it does not depend on the database behind it.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(Tx) error) error
+
+	// Update runs fn in a read-write transaction. If fn fails, none of its
+	// changes are kept.
+	Update(fn func(Tx) error) error
+}
+
+// Tx is a transaction on a Store. Values returned by Get and ForEach are
+// only valid during the transaction.
+type Tx interface {
+	// Get returns the value of a key, or nil if the key or its bucket do
+	// not exist.
+	Get(bucket, key string) []byte
+
+	// Put sets the value of a key, creating its bucket if required.
+	Put(bucket, key string, value []byte) error
+
+	// Delete removes a key, if it exists.
+	Delete(bucket, key string) error
+
+	// ForEach calls fn for every key of a bucket in key order. A missing
+	// bucket has no keys.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+
+	// Len returns the number of keys of a bucket.
+	Len(bucket string) int
+
+	// Buckets returns the names of the buckets nested in a bucket, in
+	// order.
+	Buckets(bucket string) []string
+
+	// DeleteBucket removes a bucket and all buckets nested in it, if it
+	// exists.
+	DeleteBucket(bucket string) error
+}
+
+// mailCounts returns the number of good and junk mails learned into the
+// statistics below prefix.
+func mailCounts(tx Tx, prefix string) (good, junk uint64, err error) {
+	good, err = countOf(tx, prefix+"Statistics", "ProcessedGood")
+	if err != nil {
+		return good, junk, err
+	}
+
+	junk, err = countOf(tx, prefix+"Statistics", "ProcessedJunk")
+
+	return good, junk, err
+}
+
+// boltStore is a Store in a bolt database, nesting buckets along their
+// paths. The values of the learned model and of the history are encrypted if
+// encryption keys are set, see SetEncryptionKeys.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore returns the Store of a bolt database.
+func NewBoltStore(db *bolt.DB) Store {
+	return boltStore{db: db}
+}
+
+func (s boltStore) View(fn func(Tx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+func (s boltStore) Update(fn func(Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+// boltTx is a Tx of a boltStore
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Get(bucket, key string) []byte {
+	b := bucketPath(t.tx, bucket)
+	if b == nil {
+		return nil
+	}
+
+	value := b.Get([]byte(key))
+	if !sealedPath(bucket) {
+		return value
+	}
+
+	// a value that cannot be decrypted is returned as it is, so it fails
+	// to be read like any other corrupt value
+	plain, err := decryptValue(bucket, key, value)
+	if err != nil {
+		return value
+	}
+
+	return plain
+}
+
+func (t boltTx) Put(bucket, key string, value []byte) error {
+	b, err := createBucketPath(t.tx, bucket)
+	if err != nil {
+		return err
+	}
+
+	if sealedPath(bucket) {
+		// sealing an unchanged value again would only use up a nonce
+		if sealedAs(bucket, key, b.Get([]byte(key)), value) {
+			return nil
+		}
+
+		value, err = encryptValue(bucket, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.Put([]byte(key), value)
+}
+
+func (t boltTx) Delete(bucket, key string) error {
+	b := bucketPath(t.tx, bucket)
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete([]byte(key))
+}
+
+func (t boltTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b := bucketPath(t.tx, bucket)
+	if b == nil {
+		return nil
+	}
+
+	sealed := sealedPath(bucket)
+
+	return b.ForEach(func(k, v []byte) error {
+		// nested buckets have no value
+		if v == nil {
+			return nil
+		}
+		if sealed {
+			if plain, err := decryptValue(bucket, string(k), v); err == nil {
+				v = plain
+			}
+		}
+		return fn(string(k), v)
+	})
+}
+
+func (t boltTx) Len(bucket string) int {
+	b := bucketPath(t.tx, bucket)
+	if b == nil {
+		return 0
+	}
+
+	return b.Stats().KeyN
+}
+
+func (t boltTx) Buckets(bucket string) (names []string) {
+	b := bucketPath(t.tx, bucket)
+	if b == nil {
+		return names
+	}
+
+	_ = b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			names = append(names, string(k))
+		}
+		return nil
+	})
+
+	return names
+}
+
+func (t boltTx) DeleteBucket(bucket string) error {
+	i := strings.LastIndex(bucket, "/")
+	if i < 0 {
+		if t.tx.Bucket([]byte(bucket)) == nil {
+			return nil
+		}
+		return t.tx.DeleteBucket([]byte(bucket))
+	}
+
+	parent := bucketPath(t.tx, bucket[:i])
+	if parent == nil || parent.Bucket([]byte(bucket[i+1:])) == nil {
+		return nil
+	}
+
+	return parent.DeleteBucket([]byte(bucket[i+1:]))
+}
@@ -0,0 +1,189 @@
+package sisyphus_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	. "github.com/carlostrub/sisyphus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+
+	BeforeEach(func() {
+		err = LoadMaildirs([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dbs, err = LoadDatabases([]Maildir{"test/Maildir"})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		CloseDatabases(dbs)
+
+		err = os.Remove("test/Maildir/sisyphus.db")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	// both implementations must behave the same
+	stores := map[string]func() Store{
+		"bolt":   func() Store { return NewBoltStore(dbs["test/Maildir"]) },
+		"memory": NewMemoryStore,
+	}
+
+	for name, newStore := range stores {
+		newStore := newStore
+
+		Context("Keys and buckets in "+name, func() {
+			var s Store
+
+			BeforeEach(func() {
+				s = newStore()
+
+				err = s.Update(func(tx Tx) error {
+					err := tx.Put("Periods/2017-02/Statistics", "b", []byte("2"))
+					if err != nil {
+						return err
+					}
+					err = tx.Put("Periods/2017-03/Statistics", "a", []byte("1"))
+					if err != nil {
+						return err
+					}
+					return tx.Put("Periods/2017-03/Wordlists/Good", "c", []byte("3"))
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+			})
+
+			It("Reads what was written", func() {
+				err = s.View(func(tx Tx) error {
+					Ω(tx.Get("Periods/2017-03/Statistics", "a")).Should(Equal([]byte("1")))
+					Ω(tx.Get("Periods/2017-03/Statistics", "b")).Should(BeNil())
+					Ω(tx.Get("Missing", "a")).Should(BeNil())
+					Ω(tx.Len("Periods/2017-03/Statistics")).Should(Equal(1))
+					Ω(tx.Buckets("Periods")).Should(Equal([]string{"2017-02", "2017-03"}))
+					Ω(tx.Buckets("Periods/2017-03")).Should(Equal([]string{"Statistics", "Wordlists"}))
+
+					var keys []string
+					err := tx.ForEach("Periods/2017-03", func(k string, _ []byte) error {
+						keys = append(keys, k)
+						return nil
+					})
+					Ω(keys).Should(BeEmpty())
+
+					return err
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+			})
+
+			It("Deletes keys and buckets", func() {
+				err = s.Update(func(tx Tx) error {
+					err := tx.Delete("Periods/2017-02/Statistics", "b")
+					if err != nil {
+						return err
+					}
+					return tx.DeleteBucket("Periods/2017-03")
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+
+				err = s.View(func(tx Tx) error {
+					Ω(tx.Len("Periods/2017-02/Statistics")).Should(Equal(0))
+					Ω(tx.Get("Periods/2017-03/Wordlists/Good", "c")).Should(BeNil())
+					Ω(tx.Buckets("Periods")).Should(Equal([]string{"2017-02"}))
+					return nil
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+			})
+
+			It("Refills a bucket deleted in the same update", func() {
+				err = s.Update(func(tx Tx) error {
+					err := tx.DeleteBucket("Periods/2017-03")
+					if err != nil {
+						return err
+					}
+					return tx.Put("Periods/2017-03/Statistics", "d", []byte("4"))
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+
+				err = s.View(func(tx Tx) error {
+					Ω(tx.Get("Periods/2017-03/Statistics", "a")).Should(BeNil())
+					Ω(tx.Get("Periods/2017-03/Statistics", "d")).Should(Equal([]byte("4")))
+					Ω(tx.Get("Periods/2017-03/Wordlists/Good", "c")).Should(BeNil())
+					Ω(tx.Len("Periods/2017-03/Statistics")).Should(Equal(1))
+					return nil
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+			})
+
+			It("Keeps nothing of a failed update", func() {
+				err = s.Update(func(tx Tx) error {
+					err := tx.Put("Periods/2017-02/Statistics", "b", []byte("changed"))
+					if err != nil {
+						return err
+					}
+					err = tx.DeleteBucket("Periods/2017-03")
+					if err != nil {
+						return err
+					}
+					return errors.New("failed")
+				})
+				Ω(err).Should(HaveOccurred())
+
+				err = s.View(func(tx Tx) error {
+					Ω(tx.Get("Periods/2017-02/Statistics", "b")).Should(Equal([]byte("2")))
+					Ω(tx.Get("Periods/2017-03/Statistics", "a")).Should(Equal([]byte("1")))
+					return nil
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+			})
+		})
+	}
+
+	Context("Classify from memory", func() {
+		It("Learns and classifies without a database", func() {
+			s := NewMemoryStore()
+
+			m = &Mail{
+				Key:  "1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa",
+				Junk: true,
+			}
+			err = m.LearnStore(s, "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			m = &Mail{
+				Key: "1488230510.M141612P8565.mail.carlostrub.ch,S=5978,W=6119",
+			}
+			err = m.LearnStore(s, "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			answer, prob, err := JunkStore(s, []string{"london"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(prob).Should(Equal(1.0))
+			Ω(answer).Should(BeTrue())
+
+			raw, err := ioutil.ReadFile("test/Maildir/.Junk/cur/1488226337.M327833P8269.mail.carlostrub.ch,S=6960,W=7161:2,Sa")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile("test/Maildir/new/1500000000.M9P9.test", raw, 0600)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove("test/Maildir/new/1500000000.M9P9.test")
+
+			m = &Mail{
+				Key:    "1500000000.M9P9.test",
+				DryRun: true,
+			}
+			err = m.ClassifyStore(s, "test/Maildir")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(m.Junk).Should(BeTrue())
+
+			// the database was not touched
+			gTotal, jTotal, _, _ := Info(dbs["test/Maildir"])
+			Ω(gTotal).Should(Equal(uint64(0)))
+			Ω(jTotal).Should(Equal(uint64(0)))
+
+			entries, err := History(dbs["test/Maildir"], HistoryFilter{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(BeEmpty())
+		})
+	})
+})